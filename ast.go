@@ -0,0 +1,296 @@
+package battlestarlib
+
+// Node is a statement in the abstract syntax tree of a Battlestar program
+type Node interface {
+	// Pos returns the position of the first token of the statement
+	Pos() Position
+	// Tokens returns the tokens the statement was parsed from, after built-in functions have been reduced
+	Tokens() Statement
+}
+
+// node is embedded in all statement types, and keeps the tokens that the statement was parsed from
+type node struct {
+	st Statement
+}
+
+// Pos returns the position of the first token of the statement
+func (n node) Pos() Position {
+	if len(n.st) == 0 {
+		return Position{}
+	}
+	return n.st[0].Position
+}
+
+// Tokens returns the tokens the statement was parsed from
+func (n node) Tokens() Statement {
+	return n.st
+}
+
+// Comparison is a comparison between two operands, like "rax == 3"
+type Comparison struct {
+	Left  Token
+	Op    string // ==, !=, <, >, <=, >=, or <u, >u, <=u and >=u for comparing unsigned numbers
+	Right Token
+}
+
+// Condition is one or more comparisons joined by && and ||, like "rax > 0 && rbx < 4 || rcx == 0".
+// && binds tighter than ||, so the comparisons are kept in groups that are joined by ||.
+type Condition struct {
+	Any [][]Comparison // the condition is true if all the comparisons in any of the groups are true
+}
+
+// These are all the different types of statements
+type (
+	// ConstDecl declares constant data, like: const msg = "Hello", 10
+	ConstDecl struct {
+		node
+		Name   string
+		Values []Token
+	}
+
+	// VarDecl reserves memory, like: var buf 1024
+	VarDecl struct {
+		node
+		Name string
+		Size Token
+	}
+
+	// Local reserves memory on the stack for a local variable in a function, like: local buf 64
+	Local struct {
+		node
+		Name string
+		Size Token
+	}
+
+	// DataCopy copies data from a constant to a variable, like: buf = msg
+	DataCopy struct {
+		node
+		To, From string
+	}
+
+	// DataAppend appends data from a constant to a variable, like: buf += msg
+	DataAppend struct {
+		node
+		To, From string
+	}
+
+	// Syscall is a system call or an interrupt call, like: syscall(60, 0) or int(0x80, 1, 0)
+	Syscall struct {
+		node
+		Interrupt bool
+		Args      []Token // the function number and the parameters
+	}
+
+	// Halt stops the CPU, like: halt
+	Halt struct {
+		node
+	}
+
+	// Print outputs a string on 16-bit platforms and on Windows, like: print(msg).
+	// On other platforms, print is reduced to a Syscall.
+	Print struct {
+		node
+		Name string
+	}
+
+	// Return returns from a function or exits the program, like: ret, exit or exit 1
+	Return struct {
+		node
+		Exit  bool
+		Value *Token // the exit code, or the return value, if any
+	}
+
+	// MemStore writes to memory, like: mem 0x1000 = rax or membyte rdi = 65
+	MemStore struct {
+		node
+		Size    string // "", "BYTE", "WORD" or "DOUBLE"
+		Address Token
+		Value   Token
+	}
+
+	// MemLoad reads from memory, like: rax = mem 0x1000 or al = readbyte rdi
+	MemLoad struct {
+		node
+		Size     string // "", "BYTE", "WORD" or "DOUBLE"
+		Register Token
+		Address  Token
+	}
+
+	// If starts an if block that ends with "end", like: rax == 3.
+	// If blocks can be nested, and may have elif and else branches.
+	If struct {
+		node
+		Cond Condition
+	}
+
+	// Elif starts a branch of an if block that is run if no previous comparison was true, like: elif rax == 4
+	Elif struct {
+		node
+		Cond Condition
+	}
+
+	// Else starts the branch of an if block that is run if no comparison was true, like: else
+	Else struct {
+		node
+	}
+
+	// Assign assigns a value, name or register to a register, like: rax = 42
+	Assign struct {
+		node
+		Register Token
+		Value    Token
+	}
+
+	// Disregard disregards a value, like: _ = rax
+	Disregard struct {
+		node
+		Value Token
+	}
+
+	// StackOp pushes and pops, like: rax -> stack, stack -> rbx or rax -> rbx
+	StackOp struct {
+		node
+		From, To Token
+	}
+
+	// Operation is an arithmetic or bitwise operation on a register, like: rax += 3 or rbx <<< 1
+	Operation struct {
+		node
+		Register Token
+		Op       TokenType // ADDITION, SUBTRACTION, SHL and so on
+		Operand  Token
+	}
+
+	// ListStore assigns to an element of a list, like: funparam[0] = rax
+	ListStore struct {
+		node
+		List, Index Token
+		Value       Token
+	}
+
+	// ListLoad assigns an element of a list to a register, like: rax = funparam[0]
+	ListLoad struct {
+		node
+		Register    Token
+		List, Index Token
+	}
+
+	// ListCopy copies an element of a list to an element of another list, like: sysparam[0] = funparam[1]
+	ListCopy struct {
+		node
+		To, ToIndex     Token
+		From, FromIndex Token
+	}
+
+	// AsmPassthrough is inline assembly for one platform, like: asm 64 mov rax, 1
+	AsmPassthrough struct {
+		node
+		Bits        int
+		Instruction []Token
+	}
+
+	// FunDecl starts a function that ends with "ret" or "end", like: fun main
+	FunDecl struct {
+		node
+		Name   string
+		Params []string // parameter names, like x and y for: fun add(x, y)
+	}
+
+	// Call calls a function, like: call hello, call add(rax, 5) or just: add(rax, 5)
+	Call struct {
+		node
+		Name     string
+		Implicit bool    // called by just giving the name of the function?
+		Args     []Token // registers, values or names that are passed to the function
+		Result   *Token  // the register that the return value is stored in, if any, like rbx for: rbx = call f
+	}
+
+	// Counter sets the loop counter, like: counter 10
+	Counter struct {
+		node
+		Value Token
+	}
+
+	// SetValue sets the value that is written by write and loopwrite, like: value 0x0741
+	SetValue struct {
+		node
+		Value Token
+	}
+
+	// LoopWrite writes the value counter times, like: loopwrite
+	LoopWrite struct {
+		node
+	}
+
+	// Write writes the value once, like: write
+	Write struct {
+		node
+	}
+
+	// Loop starts a loop that ends with "end", like: loop 10, loop or rawloop.
+	// Loops can be nested, and can be given a name for break and continue, like: outer: loop 10
+	Loop struct {
+		node
+		Raw   bool   // a rawloop, that does not keep track of the counter?
+		Count *Token // the number of iterations, or nil for endless loops
+		Name  string // the name of the loop, if any
+	}
+
+	// While starts a loop that runs for as long as the condition is true, and ends with "end", like: while rax < 10
+	While struct {
+		node
+		Cond Condition
+		Name string // the name of the loop, if any
+	}
+
+	// Address sets the address that is written to, like: address 0xb800:0
+	Address struct {
+		node
+		Value Token
+	}
+
+	// Bootable marks the program as a bootable kernel, like: bootable
+	Bootable struct {
+		node
+	}
+
+	// Extern declares an external symbol, like: extern printf
+	Extern struct {
+		node
+		Name string
+	}
+
+	// Break breaks out of a loop, like: break, break rax == 3 or break outer
+	Break struct {
+		node
+		Label string     // the name of the loop to break out of, or empty for the innermost loop
+		Cond  *Condition // break only if true, if not nil
+	}
+
+	// Continue jumps to the top of a loop, like: continue, continue rax == 3 or continue outer
+	Continue struct {
+		node
+		Label string     // the name of the loop to continue, or empty for the innermost loop
+		Cond  *Condition // continue only if true, if not nil
+	}
+
+	// Endless marks the program as never returning, like: endless
+	Endless struct {
+		node
+	}
+
+	// End ends an if block, a loop or a function, like: end
+	End struct {
+		node
+	}
+
+	// NoRet ends without returning, like: noret
+	NoRet struct {
+		node
+	}
+
+	// InlineC marks the start of a block of inline C, like: inline_c
+	InlineC struct {
+		node
+	}
+)
@@ -0,0 +1,126 @@
+package battlestarlib
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// callingConvention describes how parameters are passed to functions, and which registers a function may change
+type callingConvention struct {
+	name           string   // the short name of the ABI, like "sysv"
+	intRegisters   []string // registers for integer and pointer parameters, in order
+	floatRegisters []string // registers for floating-point parameters, in order
+	stackOffset    int      // offset from the base pointer to the first parameter on the stack, past the return address and the saved base pointer
+	slotSize       int      // the size of a parameter on the stack, in bytes
+	returnRegister string   // the register that holds the return value
+	callerSaved    []string // registers that the called function may change
+	sharedSlots    bool     // each parameter uses up both an integer and a floating-point register, like on Windows
+	shadowSpace    int      // the number of bytes the caller must reserve on the stack for the called function, below the arguments
+}
+
+// The calling conventions for 64-bit (System V AMD64), 32-bit (cdecl) and 16-bit (near calls) platforms.
+// ref: Figure 3.4 and section 3.2.3 of the System V AMD64 ABI, and chapter 2 of the System V i386 ABI
+var callingConventions = map[int]*callingConvention{
+	64: {
+		name:           "sysv",
+		intRegisters:   []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"},
+		floatRegisters: []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7"},
+		stackOffset:    16,
+		slotSize:       8,
+		returnRegister: "rax",
+		callerSaved:    []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11"},
+	},
+	32: {
+		name:           "cdecl",
+		stackOffset:    8,
+		slotSize:       4,
+		returnRegister: "eax",
+		callerSaved:    []string{"eax", "ecx", "edx"},
+	},
+	16: {
+		name:           "near",
+		stackOffset:    4,
+		slotSize:       2,
+		returnRegister: "ax",
+		callerSaved:    []string{"ax", "cx", "dx"},
+	},
+}
+
+// The Microsoft x64 calling convention, used on 64-bit Windows. The first four parameters are passed in
+// registers, and 32 bytes of shadow space must be reserved, so the parameters on the stack start at [rbp+48].
+// Win32 programs use cdecl, like on other 32-bit platforms, and only the Windows API functions use stdcall.
+// ref: https://learn.microsoft.com/en-us/cpp/build/x64-calling-convention
+var microsoftX64 = &callingConvention{
+	name:           "ms",
+	intRegisters:   []string{"rcx", "rdx", "r8", "r9"},
+	floatRegisters: []string{"xmm0", "xmm1", "xmm2", "xmm3"},
+	stackOffset:    48,
+	slotSize:       8,
+	returnRegister: "rax",
+	callerSaved:    []string{"rax", "rcx", "rdx", "r8", "r9", "r10", "r11"},
+	sharedSlots:    true,
+	shadowSpace:    32,
+}
+
+// callingConvention returns the calling convention for the target
+func (config *TargetConfig) callingConvention() *callingConvention {
+	if (config.OS == Windows) && (config.PlatformBits == 64) {
+		return microsoftX64
+	}
+	return callingConventions[config.PlatformBits]
+}
+
+// params returns where each parameter is found by the called function, given if each parameter is floating-point or not.
+// Integer and floating-point parameters use their own registers, and the rest are passed on the stack, in order.
+// If the registers are shared, the position of a parameter decides which register it is passed in.
+// Floating-point parameters are doubles, and take at least 8 bytes on the stack.
+func (cc *callingConvention) params(float []bool, basePointer string) []string {
+	var (
+		locations  = make([]string, len(float))
+		ints, fps  int
+		stackBytes = cc.stackOffset
+	)
+	for i, f := range float {
+		if !f && (ints < len(cc.intRegisters)) {
+			locations[i] = cc.intRegisters[ints]
+			ints++
+			if cc.sharedSlots {
+				fps++
+			}
+		} else if f && (fps < len(cc.floatRegisters)) {
+			locations[i] = cc.floatRegisters[fps]
+			fps++
+			if cc.sharedSlots {
+				ints++
+			}
+		} else {
+			locations[i] = "[" + basePointer + "+" + strconv.Itoa(stackBytes) + "]"
+			size := cc.slotSize
+			if f && (size < 8) {
+				size = 8
+			}
+			stackBytes += size
+		}
+	}
+	return locations
+}
+
+// paramnum2reg returns the register or address of the integer parameter with the given number, starting at 0.
+// Function parameters and call arguments are integers and pointers, so the floating-point registers are not used by
+// the generated code yet, but they are part of the description, so that the positions on the stack follow the ABI.
+func (config *TargetConfig) paramnum2reg(num int) (string, error) {
+	if num < 0 {
+		return "", fmt.Errorf("invalid parameter number: %d", num)
+	}
+	return config.callingConvention().params(make([]bool, num+1), config.basePointer())[num], nil
+}
+
+// returnRegister returns the register that holds the return value of a function
+func (config *TargetConfig) returnRegister() string {
+	return config.callingConvention().returnRegister
+}
+
+// callerSavedRegisters returns the registers that a called function may change, according to the calling convention
+func (config *TargetConfig) callerSavedRegisters() []string {
+	return config.callingConvention().callerSaved
+}
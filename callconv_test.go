@@ -0,0 +1,65 @@
+package battlestarlib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallingConventions(t *testing.T) {
+	tests := []struct {
+		bits     int
+		float    string // i for integer parameters and f for floating-point parameters
+		expected string
+	}{
+		// System V AMD64: six integer registers, eight vector registers, then the stack, from [rbp+16]
+		{64, "iiiiii", "rdi rsi rdx rcx r8 r9"},
+		{64, "iiiiiiii", "rdi rsi rdx rcx r8 r9 [rbp+16] [rbp+24]"},
+		{64, "ifif", "rdi xmm0 rsi xmm1"},
+		{64, "fffffffffi", "xmm0 xmm1 xmm2 xmm3 xmm4 xmm5 xmm6 xmm7 [rbp+16] rdi"},
+		{64, "iiiiiifi", "rdi rsi rdx rcx r8 r9 xmm0 [rbp+16]"},
+		// cdecl: everything on the stack, from [ebp+8], where doubles take 8 bytes
+		{32, "iii", "[ebp+8] [ebp+12] [ebp+16]"},
+		{32, "ifi", "[ebp+8] [ebp+12] [ebp+20]"},
+		// 16-bit near calls: everything on the stack, from [bp+4]
+		{16, "ii", "[bp+4] [bp+6]"},
+	}
+	for _, test := range tests {
+		config, err := NewTargetConfig(test.bits, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var float []bool
+		for _, r := range test.float {
+			float = append(float, r == 'f')
+		}
+		if params := strings.Join(config.callingConvention().params(float, config.basePointer()), " "); params != test.expected {
+			t.Errorf("%d-bit %s: expected %s, got %s", test.bits, test.float, test.expected, params)
+		}
+	}
+
+	// Microsoft x64: the position of a parameter decides the register, and the stack starts above the shadow space
+	config, err := NewWindowsTargetConfig(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params := strings.Join(config.callingConvention().params([]bool{false, true, false, false, false}, config.basePointer()), " "); params != "rcx xmm1 r8 r9 [rbp+48]" {
+		t.Errorf("Microsoft x64: expected rcx xmm1 r8 r9 [rbp+48], got %s", params)
+	}
+
+	// Every register that parameters or return values are passed in must be known
+	for _, cc := range []*callingConvention{callingConventions[64], callingConventions[32], callingConventions[16], microsoftX64} {
+		for _, reg := range append(append(append([]string{cc.returnRegister}, cc.intRegisters...), cc.floatRegisters...), cc.callerSaved...) {
+			if !has(registers, reg) {
+				t.Errorf("unknown register: %s", reg)
+			}
+		}
+	}
+
+	config, err = NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg, err := config.paramnum2reg(6); err != nil || reg != "[rbp+16]" {
+		t.Errorf("expected the seventh parameter to be at [rbp+16], got %s", reg)
+	}
+}
@@ -0,0 +1,1881 @@
+package battlestarlib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Generate outputs assembly code for statements that have been parsed with Parse.
+// The constants and the rest of the assembly code are returned separately, like for CompileTokens.
+// Statements that can not be compiled are skipped, and all problems are collected in the ProgramState.
+func (config *TargetConfig) Generate(nodes []Node, ps *ProgramState) (string, string, error) {
+	if err := config.check(); err != nil {
+		return "", "", err
+	}
+	asmcode := ""
+	constants := ""
+	bsscode := ""
+	for _, n := range nodes {
+		asmline, err := config.generate(n, ps)
+		if err != nil {
+			ps.addError(n.Tokens(), err)
+			continue
+		}
+		switch n.(type) {
+		case *ConstDecl:
+			constants += asmline + "\n"
+		case *VarDecl:
+			// Variables are gathered for the .bss section
+			bsscode += asmline + "\n"
+		default:
+			asmcode += asmline + "\n"
+		}
+	}
+	// Add .bss section, if any
+	if bsscode != "" {
+		asmcode += "\nsection .bss\n" + bsscode
+	}
+	return strings.TrimSpace(constants), asmcode, ps.diagnostics.Err()
+}
+
+// generate outputs assembly code for the given statement
+func (config *TargetConfig) generate(n Node, ps *ProgramState) (string, error) {
+	if _, ok := n.(*Local); !ok && (ps.inFunction != "") {
+		// Local variables can only be declared before any other statements in a function
+		ps.pastLocals = true
+	}
+	switch n := n.(type) {
+	case *Syscall:
+		return config.syscallOrInterrupt(n.st, !n.Interrupt, ps)
+	case *VarDecl:
+		return config.genVarDecl(n, ps)
+	case *Local:
+		return config.genLocal(n, ps)
+	case *ConstDecl:
+		return config.genConstDecl(n, ps)
+	case *DataCopy:
+		return config.genDataCopy(n, ps)
+	case *DataAppend:
+		return config.genDataAppend(n, ps)
+	case *Halt:
+		return config.genHalt(n, ps)
+	case *Print:
+		return config.genPrint(n, ps)
+	case *Return:
+		return config.genReturn(n, ps)
+	case *MemStore:
+		return config.genMemStore(n, ps)
+	case *MemLoad:
+		return config.genMemLoad(n, ps)
+	case *If:
+		return config.genIf(n, ps)
+	case *Elif:
+		return config.genElif(n, ps)
+	case *Else:
+		return config.genElse(n, ps)
+	case *Assign:
+		return config.genAssign(n, ps)
+	case *Disregard:
+		return config.genDisregard(n, ps)
+	case *StackOp:
+		return config.genStackOp(n, ps)
+	case *Operation:
+		return config.genOperation(n, ps)
+	case *ListStore:
+		return config.genListStore(n, ps)
+	case *ListLoad:
+		return config.genListLoad(n, ps)
+	case *ListCopy:
+		return config.genListCopy(n, ps)
+	case *AsmPassthrough:
+		return config.genAsmPassthrough(n, ps)
+	case *FunDecl:
+		return config.genFunDecl(n, ps)
+	case *Call:
+		return config.genCall(n, ps)
+	case *Counter:
+		return config.genCounter(n, ps)
+	case *SetValue:
+		return config.genSetValue(n, ps)
+	case *LoopWrite:
+		return config.genLoopWrite(n, ps)
+	case *Write:
+		return config.genWrite(n, ps)
+	case *Loop:
+		return config.genLoop(n, ps)
+	case *While:
+		return config.genWhile(n, ps)
+	case *Address:
+		return config.genAddress(n, ps)
+	case *Bootable:
+		return config.genBootable(n, ps)
+	case *Extern:
+		return config.genExtern(n, ps)
+	case *Break:
+		return config.genBreak(n, ps)
+	case *Continue:
+		return config.genContinue(n, ps)
+	case *Endless:
+		return config.genEndless(n, ps)
+	case *End:
+		return config.genEnd(n, ps)
+	case *NoRet:
+		return config.genNoRet(n, ps)
+	case *InlineC:
+		return config.genInlineC(n, ps)
+	}
+	return "", &CompileError{Position: n.Pos(), Message: fmt.Sprintf("no code generator for %T", n)}
+}
+
+// memoryOperand returns the given address as a memory operand, like [rdi],
+// unless it already is one, like the address of a local variable
+func memoryOperand(address Token) string {
+	if address.T == MEMEXP {
+		return address.Value
+	}
+	return "[" + address.Value + "]"
+}
+
+// genMemStore writes a value to memory
+func (config *TargetConfig) genMemStore(n *MemStore, ps *ProgramState) (string, error) {
+	val := n.Value.Value
+	switch n.Size {
+	case "":
+		if bits := qualifierBits(n.Address.extra); bits > 0 {
+			// A local variable of a known size
+			if n.Value.T == REGISTER {
+				val = registerOfSize(val, bits)
+			}
+			return "\tmov " + n.Address.extra + " " + memoryOperand(n.Address) + ", " + val + "\t\t; " + "memory assignment" + "\n", nil
+		}
+		return "\tmov " + memoryOperand(n.Address) + ", " + val + "\t\t; " + "memory assignment" + "\n", nil
+	case "BYTE":
+		if n.Value.T == REGISTER {
+			val = downgradeToByte(val)
+		}
+	case "WORD":
+		if n.Value.T == REGISTER {
+			val = regToWord(val)
+		}
+	case "DOUBLE":
+		if n.Value.T == REGISTER {
+			val = regToDouble(val)
+		}
+	}
+	return "\tmov " + n.Size + " " + memoryOperand(n.Address) + ", " + val + "\t\t; " + "memory assignment" + "\n", nil
+}
+
+// genMemLoad reads a value from memory
+func (config *TargetConfig) genMemLoad(n *MemLoad, ps *ProgramState) (string, error) {
+	val := n.Register.Value
+	switch n.Size {
+	case "":
+		return "\tmov " + val + ", " + memoryOperand(n.Address) + "\t\t; memory assignment\n", nil
+	case "BYTE":
+		val = downgradeToByte(val)
+	case "WORD":
+		val = regToWord(val)
+	case "DOUBLE":
+		val = regToDouble(val)
+	}
+	return "\tmov " + n.Size + " " + val + ", " + memoryOperand(n.Address) + "\t\t; memory assignment (" + strings.ToLower(n.Size) + ")\n", nil
+}
+
+// genVarDecl reserves memory in the .bss section
+func (config *TargetConfig) genVarDecl(n *VarDecl, ps *ProgramState) (string, error) {
+	varname := n.Name
+	size := n.Size.Value
+	if has(ps.definedNames, varname) {
+		return "", n.st.errorf(1, "can not declare variable, name is already defined: %s", varname)
+	}
+	ps.definedNames = append(ps.definedNames, varname)
+	// Store the name of the declared variable in variables + the length,
+	// unless the size depends on the length of a constant, which is only known when assembling
+	if !strings.Contains(size, "_length_of_") {
+		bytes, err := n.Size.Number()
+		if err != nil || bytes < 0 {
+			return "", n.st.errorf(2, "%s is not a valid number of bytes to reserve", size)
+		}
+		ps.variables[varname] = int(bytes)
+	}
+	// Will be placed in the .bss section at the end
+	bsscode := varname + ": resb " + size + "\t\t\t\t; reserve " + size + " bytes as " + varname + "\n"
+	bsscode += "_capacity_of_" + varname + " equ " + size + "\t\t; size of reserved memory\n"
+	bsscode += "_length_of_" + varname + ": "
+	switch config.PlatformBits {
+	case 64:
+		bsscode += "resd 1"
+	case 32:
+		bsscode += "resw 1"
+	case 16:
+		bsscode += "resb 1"
+	}
+	bsscode += "\t\t; current length of contents (points to after the data)\n"
+	return bsscode, nil
+}
+
+// genLocal reserves memory on the stack for a local variable
+func (config *TargetConfig) genLocal(n *Local, ps *ProgramState) (string, error) {
+	st := n.st
+	if config.PlatformBits == 16 {
+		return "", st.errorf(0, "local variables are not supported on 16-bit platforms, use var instead")
+	}
+	if ps.inFunction == "" {
+		return "", st.errorf(0, "local variables can only be declared inside functions")
+	}
+	if ps.pastLocals {
+		return "", st.errorf(0, "local variables must be declared at the start of the function")
+	}
+	_, isParam := ps.frame.params[n.Name]
+	if _, isLocal := ps.frame.locals[n.Name]; isLocal || isParam || has(ps.definedNames, n.Name) {
+		return "", st.errorf(1, "can not declare local variable, name is already defined: %s", n.Name)
+	}
+	size, err := n.Size.Number()
+	if err != nil || size <= 0 {
+		return "", st.errorf(2, "%s is not a valid number of bytes to reserve", n.Size.Value)
+	}
+	bp, sp := config.basePointer(), config.stackPointer()
+	first := len(ps.frame.locals) == 0
+	asmcode := ""
+	if first && ((ps.inFunction == "main") || (ps.inFunction == config.LinkerStartFunction)) {
+		// The stack frame is not set up in the main/_start/start function, unless it is needed
+		asmcode += "\t;--- setup stack frame ---\n"
+		asmcode += "\tpush " + bp + "\t\t\t; save old base pointer\n"
+		asmcode += "\tmov " + bp + ", " + sp + "\t\t\t; use stack pointer as new base pointer\n"
+	}
+	before := ps.frame.size
+	offset := ps.frame.declare(n.Name, int(size))
+	// Reserve multiples of the stack alignment, 16 bytes on 64-bit and 4 bytes on 32-bit
+	align := 4
+	if config.PlatformBits == 64 {
+		align = 16
+	}
+	reserve := (offset+align-1)/align*align - (before+align-1)/align*align
+	address := config.localAddress(offset)
+	if reserve > 0 {
+		asmcode += "\tsub " + sp + ", " + strconv.Itoa(reserve) + "\t\t\t; reserve stack space, " + n.Name + " is at " + address + "\n"
+	} else {
+		asmcode += "\t\t\t\t; " + n.Name + " is at " + address + "\n"
+	}
+	if first && (config.PlatformBits == 64) {
+		asmcode += "\tand rsp, -16\t\t\t; align the stack to 16 bytes\n"
+	}
+	return asmcode, nil
+}
+
+// genConstDecl places constant data in the .data section
+func (config *TargetConfig) genConstDecl(n *ConstDecl, ps *ProgramState) (string, error) {
+	constname := n.Name
+	first := n.Values[0]
+	if has(ps.definedNames, constname) {
+		return "", n.st.errorf(1, "can not declare constant, name is already defined: %s", constname)
+	}
+	if (first.T == VALIDNAME) && !has(ps.definedNames, first.Value) {
+		return "", n.st.errorf(3, "can't assign %s to %s because %s is undefined", first.Value, constname, first.Value)
+	}
+	// Store the name of the declared constant in defined_names
+	ps.definedNames = append(ps.definedNames, constname)
+	if value, ok := n.numericValue(); ok {
+		ps.constants[constname] = value
+	}
+	// For the .DATA section (recognized by the keyword)
+	asmcode := ""
+	if first.T == VALUE {
+		switch config.PlatformBits {
+		case 64:
+			asmcode += constname + ":\tdq "
+		case 32:
+			asmcode += constname + ":\tdw "
+		case 16:
+			asmcode += constname + ":\tdb "
+		}
+	} else {
+		asmcode += constname + ":\tdb "
+		ps.dataNotValueTypes = append(ps.dataNotValueTypes, constname)
+	}
+	for i, value := range n.Values {
+		asmcode += value.Value
+		// Add a comma between every element but the last one
+		if (i + 1) != len(n.Values) {
+			asmcode += ", "
+		}
+	}
+	if first.T == STRING {
+		asmcode += "\t\t; constant string\n"
+		//if config.platformBits == 16 {
+		// Add an extra $, for safety, if on a 16-bit platform. Needed for print().
+		// TODO: Remove, use a different int 21h call instead!
+		//asmcode += "\tdb \"$\"\t\t\t; end of string, for when using ah=09/int 21h\n"
+		//}
+	} else {
+		asmcode += "\t\t; constant value\n"
+	}
+	// Special naming for storing the length for later
+	asmcode += "_length_of_" + constname + " equ $ - " + constname + "\t; size of constant value\n"
+	return asmcode, nil
+}
+
+// genDataCopy copies data from a constant to a variable
+func (config *TargetConfig) genDataCopy(n *DataCopy, ps *ProgramState) (string, error) {
+	// Copying data from constants to variables (reserved memory in the .bss section)
+	asmcode := ""
+	from := n.From
+	to := n.To
+	lengthexpr := "_length_of_" + from
+	toPosition := "[_length_of_" + to + "]"
+	// TODO: Make this a lot smarter and handle copying ranges of data, adr or value
+	// TODO: Actually, redesign the whole language
+	switch config.PlatformBits {
+	case 64:
+		asmcode += "\t" + config.loadAddress("rdi", to) + "\t\t\t; copy bytes from " + from + " to " + to + "\n"
+		asmcode += "\t" + config.loadAddress("rsi", from) + "\n"
+		asmcode += "\tmov rcx, " + lengthexpr + "\n"
+		//asmcode += "\tmov QWORD " + toPosition + ", " + to + "\n"
+		asmcode += "\tmov " + toPosition + ", rcx" + "\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n" // optimized ok on 64-bit CPUs
+	case 32:
+		asmcode += "\tmov edi, " + to + "\t\t\t; copy bytes from " + from + " to " + to + "\n"
+		asmcode += "\tmov esi, " + from + "\n"
+		asmcode += "\tmov ecx, " + lengthexpr + "\n"
+		asmcode += "\tmov " + toPosition + ", ecx\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n" // optimized ok on 32-bit CPUs
+	case 16:
+		// TODO: Test this
+		asmcode += "\tmov di, " + to + "\t\t\t; copy bytes from " + from + " to " + to + "\n"
+		asmcode += "\tmov si, " + from + "\n"
+		asmcode += "\tmov cx, " + lengthexpr + "\n"
+		asmcode += "\tmov " + toPosition + ", cx\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	}
+	return asmcode, nil
+}
+
+// genDataAppend appends data from a constant to a variable
+func (config *TargetConfig) genDataAppend(n *DataAppend, ps *ProgramState) (string, error) {
+	// Copying data from constants to variables (reserved memory in the .bss section)
+	asmcode := ""
+	from := n.From
+	to := n.To
+	lengthAddr := "[_length_of_" + to + "]"
+	// TODO: Make this a lot smarter and handle copying ranges of data, adr or value
+	// TODO: Actually, redesign the whole language
+	switch config.PlatformBits {
+	case 64:
+		asmcode += "\t" + config.loadAddress("rdi", to) + "\t\t; add bytes from \"" + from + "\" to " + to + "\n"
+		asmcode += "\tadd rdi, " + lengthAddr + "\n"
+		asmcode += "\t" + config.loadAddress("rsi", from) + "\n"
+		asmcode += "\tmov rcx, _length_of_" + from + "\n"
+		asmcode += "\tadd " + lengthAddr + ", rcx" + "\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	case 32:
+		asmcode += "\tmov edi, " + to + "\t\t; add bytes from \"" + from + "\" to " + to + "\n"
+		asmcode += "\tadd edi, " + lengthAddr + "\n"
+		asmcode += "\tmov esi, " + from + "\n"
+		asmcode += "\tmov ecx, _length_of_" + from + "\n"
+		asmcode += "\tadd " + lengthAddr + ", ecx" + "\n"
+		asmcode += "\tcld\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	case 16:
+		// TODO: Test this
+		asmcode += "\tmov di, " + to + "\t\t; add bytes from \"" + from + "\" to " + to + "\n"
+		asmcode += "\tadd di, " + lengthAddr + "\n"
+		asmcode += "\tmov si, " + from + "\n"
+		asmcode += "\tmov cx, _length_of_" + from + "\n"
+		asmcode += "\tadd " + lengthAddr + ", cx" + "\n"
+		asmcode += "\trep movsb\t\t\t\t; copy bytes\n"
+	}
+	return asmcode, nil
+}
+
+// genHalt stops the CPU
+func (config *TargetConfig) genHalt(n *Halt, ps *ProgramState) (string, error) {
+	asmcode := "\t; --- full stop ---\n"
+	asmcode += "\tcli\t\t; clear interrupts\n"
+	asmcode += ".hang:\n"
+	asmcode += "\thlt\n"
+	asmcode += "\tjmp .hang\t; loop forever\n\n"
+	return asmcode, nil
+}
+
+// genPrint outputs a string on 16-bit platforms and on Windows
+func (config *TargetConfig) genPrint(n *Print, ps *ProgramState) (string, error) {
+	if config.OS == Windows {
+		return config.windowsPrint(n.Name, ps), nil
+	}
+	asmcode := "\t; --- output string of given length ---\n"
+	asmcode += "\tmov dx, " + n.Name + "\n"
+	if _, ok := ps.variables[n.Name]; ok {
+		// A variable in .bss
+		asmcode += "\tmov cx, [_length_of_" + n.Name + "]\n"
+	} else {
+		asmcode += "\tmov cx, _length_of_" + n.Name + "\n"
+	}
+	asmcode += "\tmov bx, 1\n"
+	write, _ := config.syscallNumber("write")
+	asmcode += "\tmov ah, " + write + "\t\t; prepare to call \"Write File or Device\"\n"
+	asmcode += "\tint 0x21\n\n"
+	return asmcode, nil
+}
+
+// genReturn returns from a function, or exits the program
+func (config *TargetConfig) genReturn(n *Return, ps *ProgramState) (string, error) {
+	asmcode := ""
+	if !n.Exit && (n.Value != nil) && (ps.inFunction != "main") && (ps.inFunction != config.LinkerStartFunction) {
+		// Load the return value before the stack frame, and any local variables, are gone
+		st := Statement{Token{REGISTER, config.returnRegister(), n.Value.Position, ""}, Token{ASSIGNMENT, "=", n.Value.Position, ""}, *n.Value}
+		if n.Value.Value != st[0].Value {
+			code, err := config.genAssign(&Assign{node{st}, st[0], st[2]}, ps)
+			if err != nil {
+				return "", err
+			}
+			asmcode += code + "\n"
+		}
+	}
+	if !n.Exit {
+		// Returning from inside loops, so the counters that the loops have saved on the stack must be removed
+		for j := len(ps.blocks) - 1; j >= 0; j-- {
+			if ps.blocks[j].savesCounter() {
+				asmcode += "\tpop " + config.counterRegister() + "\t\t\t\t; restore counter\n"
+			}
+		}
+		if (ps.inFunction == "main") || (ps.inFunction == config.LinkerStartFunction) {
+			// The stack frame is only set up in the main/_start/start function if there are local variables,
+			// and it must be taken down in case the function returns, like when main is called by libc
+			if len(ps.frame.locals) > 0 {
+				bp, sp := config.basePointer(), config.stackPointer()
+				asmcode += "\t;--- takedown stack frame ---\n"
+				asmcode += "\tmov " + sp + ", " + bp + "\t\t\t; use base pointer as new stack pointer\n"
+				asmcode += "\tpop " + bp + "\t\t\t\t; get the old base pointer\n\n"
+			}
+		} else {
+			switch config.PlatformBits {
+			case 64:
+				asmcode += "\t;--- takedown stack frame ---\n"
+				asmcode += "\tmov rsp, rbp\t\t\t; use base pointer as new stack pointer\n"
+				asmcode += "\tpop rbp\t\t\t\t; get the old base pointer\n\n"
+			case 32:
+				asmcode += "\t;--- takedown stack frame ---\n"
+				asmcode += "\tmov esp, ebp\t\t\t; use base pointer as new stack pointer\n"
+				asmcode += "\tpop ebp\t\t\t\t; get the old base pointer\n\n"
+			case 16:
+				if len(ps.frame.params) > 0 {
+					asmcode += "\t;--- takedown stack frame ---\n"
+					asmcode += "\tmov sp, bp\t\t\t; use base pointer as new stack pointer\n"
+					asmcode += "\tpop bp\t\t\t\t; get the old base pointer\n\n"
+				}
+			}
+		}
+	}
+	if ps.inFunction != "" {
+		if !config.bootable(ps) && !ps.endless && (ps.inFunction == "main") {
+			asmcode += "\n\t;--- return from \"" + ps.inFunction + "\" ---\n"
+		}
+	} else if n.Exit {
+		asmcode += "\t;--- exit program ---\n"
+	} else {
+		asmcode += "\t;--- return ---\n"
+	}
+	if (n.Exit) || (ps.inFunction == "main") || (ps.inFunction == config.LinkerStartFunction) {
+		// Not returning from main/_start/start function, but exiting properly
+		exitCode := "0"
+		if n.Value != nil {
+			exitCode = n.Value.Value
+		}
+		exit, _ := config.syscallNumber("exit")
+		if (config.OS == Windows) && !config.bootable(ps) {
+			asmcode += config.windowsExit(exitCode, ps)
+		} else if !config.bootable(ps) {
+			switch config.PlatformBits {
+			case 64:
+				asmcode += "\tmov rax, " + exit + "\t\t\t; function call: " + exit + "\n\t"
+				if exitCode == "0" {
+					asmcode += "xor rdi, rdi"
+				} else {
+					asmcode += "mov rdi, " + exitCode
+				}
+				asmcode += "\t\t\t; return code " + exitCode + "\n"
+				asmcode += "\tsyscall\t\t\t\t; exit program\n"
+			case 32:
+				if config.OS.isBSD() {
+					asmcode += "\tpush dword " + exitCode + "\t\t\t; exit code " + exitCode + "\n"
+					asmcode += "\tsub esp, 4\t\t\t; the BSD way, push then subtract before calling\n"
+				}
+				asmcode += "\tmov eax, " + exit + "\t\t\t; function call: " + exit + "\n"
+				if !config.OS.isBSD() {
+					asmcode += "\t"
+					if exitCode == "0" {
+						asmcode += "xor ebx, ebx"
+					} else {
+						asmcode += "mov ebx, " + exitCode
+					}
+					asmcode += "\t\t\t; exit code " + exitCode + "\n"
+				}
+				asmcode += "\tint 0x80\t\t\t; exit program\n"
+			case 16:
+				// Unless "exit" or "noret" is specified explicitly, use "ret"
+				if n.Exit {
+					// Since we are not building a kernel, calling DOS interrupt 21h makes sense
+					asmcode += "\tmov ah, " + exit + "\t\t\t; function " + exit + "\n"
+					if exitCode == "0" {
+						asmcode += "\txor al, al\t\t\t; exit code " + exitCode + "\n"
+					} else {
+						asmcode += "\tmov al, " + exitCode + "\t\t\t; exit code " + exitCode + "\n"
+					}
+					asmcode += "\tint 0x21\t\t\t; exit program\n"
+				} else {
+					if !ps.endless {
+						asmcode += "\tret\t\t\t; exit program\n"
+					} else {
+						asmcode += "\t; endless loop, there is no return\n"
+					}
+				}
+			}
+		} else {
+			// For bootable kernels, main does not return. Hang instead.
+			ps.warn(n.st, 0, "bootable kernels has nowhere to return after the main function. You might want to use the \"halt\" builtin at the end of the main function.")
+			//asmcode += Statement{Token{BUILTIN, "halt", st[0].line, ""}}.String()
+		}
+	} else {
+		config.logf(LogCodegen, "returning from function %s", ps.inFunction)
+		// Do not return eax=0/rax=0 if no return value is explicitly provided, by design
+		// This allows the return value from the previous call to be returned instead
+		asmcode += "\tret\t\t\t\t; Return\n"
+	}
+	if (ps.inFunction != "") && (len(ps.blocks) == 0) {
+		// Exiting from the function definition, unless this is an early return from inside an if block or a loop
+		ps.inFunction = ""
+		// If the function was ended with "exit" or "ret", don't freak out if an "end" is encountered
+		ps.surpriseEndingWithExit = true
+	}
+	if ps.inlineC {
+		// Exiting from inline C
+		ps.inlineC = false
+		return "; End of inline C block", nil
+	}
+	return asmcode, nil
+}
+
+// jumpIf returns the conditional jump instruction that jumps if the given comparison is true
+func jumpIf(op string) string {
+	switch op {
+	case "==":
+		return "je"
+	case "!=":
+		return "jne"
+	case ">":
+		return "jg"
+	case "<":
+		return "jl"
+	case "<=":
+		return "jle"
+	case ">=":
+		return "jge"
+	case "<u":
+		return "jb"
+	case ">u":
+		return "ja"
+	case "<=u":
+		return "jbe"
+	case ">=u":
+		return "jae"
+	}
+	return ""
+}
+
+// jumpIfNot returns the conditional jump instruction that jumps if the given comparison is false
+func jumpIfNot(op string) string {
+	switch op {
+	case "==":
+		return "jne"
+	case "!=":
+		return "je"
+	case ">":
+		return "jle"
+	case "<":
+		return "jge"
+	case "<=":
+		return "jg"
+	case ">=":
+		return "jl"
+	case "<u":
+		return "jae"
+	case ">u":
+		return "jbe"
+	case "<=u":
+		return "ja"
+	case ">=u":
+		return "jb"
+	}
+	return ""
+}
+
+// compareAndSkip compares and jumps to the given label if the comparison is false
+func compareAndSkip(cond Comparison, label, comment string) string {
+	asmcode := "\tcmp " + cond.Left.Value + ", " + cond.Right.Value + "\t\t\t; compare\n"
+	asmcode += "\t" + jumpIfNot(cond.Op) + " " + label + "\t\t\t; " + comment + "\n"
+	return asmcode
+}
+
+// genIf starts an if block
+func (config *TargetConfig) genIf(n *If, ps *ProgramState) (string, error) {
+	label := ps.newIfLabel()
+	b := &block{kind: ifBlock, label: label, next: label + "_end"}
+	ps.pushBlock(b)
+	// Start an if block that is run if the comparison is true
+	return "\t;--- " + label + " ---\n" + ps.skipUnless(n.Cond, b.next, "skip to the next branch"), nil
+}
+
+// ifBranch checks that there is an if block to add an elif or else branch to,
+// and ends the current branch of it by jumping to the end of the if block
+func ifBranch(st Statement, ps *ProgramState) (*block, string, error) {
+	b := ps.innermostBlock()
+	if (b == nil) || (b.kind != ifBlock) {
+		return nil, "", st.errorf(0, "%s without an if block", st[0].Value)
+	}
+	if b.hasElse {
+		return nil, "", st.errorf(0, "%s after else in if block %s", st[0].Value, b.label)
+	}
+	if b.done == "" {
+		b.done = b.label + "_done"
+	}
+	asmcode := "\tjmp " + b.done + "\t\t\t; skip the rest of " + b.label + "\n"
+	asmcode += b.next + ":\t\t\t\t; " + st[0].Value + "\n"
+	return b, asmcode, nil
+}
+
+// genElif starts a branch of an if block that is run if the comparison is true,
+// and none of the previous comparisons were
+func (config *TargetConfig) genElif(n *Elif, ps *ProgramState) (string, error) {
+	b, asmcode, err := ifBranch(n.st, ps)
+	if err != nil {
+		return "", err
+	}
+	b.branchNumber++
+	b.next = b.label + "_elif" + strconv.Itoa(b.branchNumber)
+	return asmcode + ps.skipUnless(n.Cond, b.next, "skip to the next branch"), nil
+}
+
+// genElse starts a branch of an if block that is run if none of the comparisons were true
+func (config *TargetConfig) genElse(n *Else, ps *ProgramState) (string, error) {
+	b, asmcode, err := ifBranch(n.st, ps)
+	if err != nil {
+		return "", err
+	}
+	b.hasElse = true
+	b.next = ""
+	return asmcode, nil
+}
+
+// genAssign assigns a value, name or register to a register
+func (config *TargetConfig) genAssign(n *Assign, ps *ProgramState) (string, error) {
+	st := n.st
+	if n.Value.T == REGISTER {
+		return "\tmov " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value, nil
+	}
+	if (st[2].T == VALUE) && (numbits(st[2].Value) > registerBits(st[0].Value)) {
+		return "", st.errorf(2, "value does not fit in the %d-bit register %s", registerBits(st[0].Value), st[0].Value)
+	}
+	if st[2].Value == "0" {
+		return "\txor " + st[0].Value + ", " + st[0].Value + "\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value, nil
+	}
+	if bits := qualifierBits(st[2].extra); (st[2].T == MEMEXP) && (bits > 0) && (bits < registerBits(st[0].Value)) {
+		// A local variable that is smaller than the register, zero extend it
+		if bits == 32 {
+			return "\tmov " + registerOfSize(st[0].Value, 32) + ", " + st[2].extra + " " + st[2].Value + "\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value, nil
+		}
+		return "\tmovzx " + st[0].Value + ", " + st[2].extra + " " + st[2].Value + "\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value, nil
+	}
+	a := st[0].Value
+	b := st[2].Value
+	if is32bit(a) && is64bit(b) {
+		ps.warn(st, 2, "using %s as a 32-bit register when assigning", b)
+		return "\tmov " + a + ", " + downgrade(b) + "\t\t; " + a + " " + st[1].Value + " " + b, nil
+	} else if is64bit(a) && is32bit(b) {
+		ps.warn(st, 0, "using %s as a 32-bit register when assigning", a)
+		asmcode := "\txor rax, rax\t\t; clear rax\n"
+		asmcode += "\tmov " + downgrade(a) + ", " + b + "\t\t; " + a + " " + st[1].Value + " " + b
+		return asmcode, nil
+	} else if (st[2].T == VALIDNAME) && has(ps.definedNames, b) && is64bit(a) {
+		// The address of a constant, variable or function
+		return "\t" + config.loadAddress(a, b) + "\t\t; " + a + " " + st[1].Value + " " + b, nil
+	}
+	return "\tmov " + st[0].Value + ", " + st[2].Value + "\t\t; " + st[0].Value + " " + st[1].Value + " " + st[2].Value, nil
+}
+
+// genDisregard disregards a value
+func (config *TargetConfig) genDisregard(n *Disregard, ps *ProgramState) (string, error) {
+	// TODO: If the value is a function, one wishes to call it, then disregard afterwards
+	return "\t\t\t\t; Disregarding: " + n.Value.Value + "\n", nil
+}
+
+// genStackOp pushes and pops registers
+func (config *TargetConfig) genStackOp(n *StackOp, ps *ProgramState) (string, error) {
+	from, to := n.From.Value, n.To.Value
+	if to == "stack" {
+		// something -> stack (push)
+		return "\tpush " + from + "\t\t\t; " + from + " -> stack\n", nil
+	} else if from == "stack" {
+		// stack -> something (pop)
+		return "\tpop " + to + "\t\t\t\t; stack -> " + to + "\n", nil
+	}
+	// reg -> reg (push and then pop)
+	return "\tpush " + from + "\t\t\t; " + from + " -> " + to + "\n\tpop " + to + "\t\t\t\t;\n", nil
+}
+
+// genOperation performs an arithmetic or bitwise operation on a register
+func (config *TargetConfig) genOperation(n *Operation, ps *ProgramState) (string, error) {
+	st := n.st
+	if (st[1].T == ADDITION) && (st[2].T == REGISTER) {
+		return "\tadd " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " += " + st[2].Value, nil
+	} else if (st[1].T == SUBTRACTION) && (st[2].T == REGISTER) {
+		return "\tsub " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " -= " + st[2].Value, nil
+	} else if (st[1].T == MULTIPLICATION) && (st[2].T == REGISTER) {
+		if (config.PlatformBits == 16) || (registerBits(st[0].Value) == 8) {
+			return config.multiplyWithA(n)
+		}
+		if registerA(st[0].Value) {
+			return "\tmul " + st[2].Value + "\t\t\t; " + st[0].Value + " *= " + st[2].Value, nil
+		}
+		return "\timul " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " *= " + st[2].Value, nil
+	} else if (st[1].T == DIVISION) && (st[2].T == REGISTER) {
+		return config.divide(n, st[1].Value == "/s=", false)
+	}
+	if (st[0].T == REGISTER) && (st[2].T == VALUE) && (numbits(st[2].Value) > immediateBits(st[0].Value)) && ((st[1].T == ADDITION) || (st[1].T == SUBTRACTION) || (st[1].T == AND) || (st[1].T == OR) || (st[1].T == XOR)) {
+		return "", st.errorf(2, "value does not fit in a %d-bit immediate for %s", immediateBits(st[0].Value), st[0].Value)
+	}
+	if (st[1].T == ADDITION) && ((st[2].T == VALUE) || (st[2].T == MEMEXP)) {
+		if st[2].Value == "1" {
+			return "\tinc " + st[0].Value + "\t\t\t; " + st[0].Value + "++", nil
+		}
+		return "\tadd " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " += " + st[2].Value, nil
+	} else if (st[1].T == SUBTRACTION) && ((st[2].T == VALUE) || (st[2].T == MEMEXP)) {
+		if st[2].Value == "1" {
+			return "\tdec " + st[0].Value + "\t\t\t; " + st[0].Value + "--", nil
+		}
+		return "\tsub " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " -= " + st[2].Value, nil
+	} else if (st[1].T == AND) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tand " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " &= " + st[2].Value, nil
+	} else if (st[1].T == OR) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tor " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " |= " + st[2].Value, nil
+		// TODO: All == MEMEXP should be followed by || st[2].t == REGEXP. In fact,
+		//       a better system is needed. Some sort of pattern matching.
+	} else if (st[1].T == XOR) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\txor " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " ^= " + st[2].Value, nil
+	} else if (st[1].T == ROL) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\trol " + st[0].Value + ", " + st[2].Value + "\t\t\t; rotate " + st[0].Value + " left" + st[2].Value, nil
+	} else if (st[1].T == ROR) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tror " + st[0].Value + ", " + st[2].Value + "\t\t\t; rotate " + st[0].Value + " right " + st[2].Value, nil
+	} else if (st[1].T == SHL) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tshl " + st[0].Value + ", " + st[2].Value + "\t\t\t; shift " + st[0].Value + " left" + st[2].Value, nil
+	} else if (st[1].T == SHR) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tshr " + st[0].Value + ", " + st[2].Value + "\t\t\t; shift " + st[0].Value + " right " + st[2].Value, nil
+	} else if (st[1].T == SAR) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tsar " + st[0].Value + ", " + st[2].Value + "\t\t\t; shift " + st[0].Value + " right " + st[2].Value + ", keeping the sign", nil
+	} else if (st[1].T == XCHG) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\txchg " + st[0].Value + ", " + st[2].Value + "\t\t\t; exchange " + st[0].Value + " and " + st[2].Value, nil
+	} else if (st[1].T == OUT) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tout " + st[0].Value + ", " + st[2].Value + "\t\t\t; output " + st[0].Value + " to IO port " + st[2].Value, nil
+	} else if (st[1].T == IN) && ((st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		return "\tin " + st[2].Value + ", " + st[0].Value + "\t\t\t; input " + st[2].Value + " from IO port " + st[0].Value, nil
+	} else if (st[1].T == MULTIPLICATION) && ((st[2].T == VALUE) || (st[2].T == MEMEXP)) {
+		if shift, ok := st[2].powerOfTwo(); ok {
+			// Shifting left works for both signed and unsigned numbers
+			return "\tshl " + st[0].Value + ", " + strconv.Itoa(shift) + "\t\t\t; " + st[0].Value + " *= " + st[2].Value, nil
+		}
+		if (config.PlatformBits == 16) || (registerBits(st[0].Value) == 8) || (registerA(st[0].Value) && (st[2].T == VALUE)) {
+			// mul can not take a value directly
+			return config.multiplyWithA(n)
+		}
+		if registerA(st[0].Value) {
+			return "\tmul " + st[2].Value + "\t\t\t; " + st[0].Value + " *= " + st[2].Value, nil
+		}
+		return "\timul " + st[0].Value + ", " + st[2].Value + "\t\t\t; " + st[0].Value + " *= " + st[2].Value, nil
+	} else if (st[1].T == DIVISION) && ((st[2].T == VALUE) || (st[2].T == MEMEXP)) {
+		signed := st[1].Value == "/s="
+		if shift, ok := st[2].powerOfTwo(); ok && !signed {
+			return "\tshr " + st[0].Value + ", " + strconv.Itoa(shift) + "\t\t; " + st[0].Value + " /= " + st[2].Value, nil
+		}
+		return config.divide(n, signed, false)
+	} else if (st[1].T == MODULO) && ((st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER)) {
+		signed := st[1].Value == "%s="
+		if shift, ok := st[2].powerOfTwo(); ok && !signed && (shift < immediateBits(st[0].Value)) {
+			mask := strconv.FormatUint((1<<uint(shift))-1, 10)
+			return "\tand " + st[0].Value + ", " + mask + "\t\t\t; " + st[0].Value + " %= " + st[2].Value, nil
+		}
+		return config.divide(n, signed, true)
+	}
+	return "", st.errorf(1, "unfamiliar 3-token expression")
+}
+
+// widen returns code for moving the given register, value or memory expression into a register that
+// is larger or of the same size, with sign extension for signed numbers and zero extension for unsigned numbers
+func widen(to string, from Token, signed bool) string {
+	if from.T != REGISTER {
+		return "\tmov " + to + ", " + from.Value
+	}
+	fromBits, toBits := registerBits(from.Value), registerBits(to)
+	switch {
+	case fromBits == toBits:
+		return "\tmov " + to + ", " + from.Value
+	case signed && (fromBits == 32):
+		return "\tmovsxd " + to + ", " + from.Value
+	case signed:
+		return "\tmovsx " + to + ", " + from.Value
+	case fromBits == 32:
+		// Moving to a 32-bit register clears the upper half of the 64-bit register
+		return "\tmov " + registerOfSize(to, 32) + ", " + from.Value
+	}
+	return "\tmovzx " + to + ", " + from.Value
+}
+
+// divide divides a register by a register, value or memory expression, as signed or unsigned numbers,
+// and places either the quotient or the remainder in the register.
+// The division is done with registers of the size of the platform. The a and d registers, and the
+// register that holds the divisor, are saved and restored if they are not the one being divided,
+// except for the d register when dividing the a register, since it then holds the remainder.
+func (config *TargetConfig) divide(n *Operation, signed, remainder bool) (string, error) {
+	st := n.st
+	reg, divisor := n.Register.Value, n.Operand
+	bits := config.PlatformBits
+	if registerBits(reg) == 8 {
+		return config.divideBytes(n, signed, remainder)
+	}
+	if registerBits(reg) > bits {
+		return "", st.errorf(0, "%s is too large for a %d-bit platform", reg, bits)
+	}
+	if (divisor.T == REGISTER) && (registerBits(divisor.Value) > bits) {
+		return "", st.errorf(2, "%s is too large for a %d-bit platform", divisor.Value, bits)
+	}
+	if number, err := divisor.Number(); (err == nil) && (number == 0) {
+		return "", st.errorf(2, "division by zero")
+	}
+	var (
+		a       = registerOfSize("ax", bits)
+		d       = registerOfSize("dx", bits)
+		full    = registerOfSize(reg, bits)
+		scratch string // a register for the divisor, that is not the one being divided
+		divide  = "div"
+		extend  = "\txor " + d + ", " + d + "\t\t; " + d + " = 0, for dividing unsigned numbers\n"
+		kind    = "unsigned"
+		what    = "division"
+	)
+	candidates := []string{registerOfSize("cx", bits), registerOfSize("bx", bits)}
+	if bits == 64 {
+		candidates = []string{"r8", "r9"}
+	}
+	for _, r := range candidates {
+		if r != full {
+			scratch = r
+			break
+		}
+	}
+	if signed {
+		divide = "idiv"
+		extend = map[int]string{16: "\tcwd", 32: "\tcdq", 64: "\tcqo"}[bits] + "\t\t\t; sign extend " + a + " into " + d + "\n"
+		kind = "signed"
+	}
+	if remainder {
+		what = "modulo"
+	}
+	asmcode := "\n\t;--- " + kind + " " + what + ": " + reg + " " + st[1].Value + " " + divisor.Value + " ---\n"
+	saved := []string{}
+	for _, r := range []string{scratch, a, d} {
+		if (r != full) && !(!remainder && (r == d) && (full == a)) {
+			asmcode += "\tpush " + r + "\t\t\t; save " + r + "\n"
+			saved = append(saved, r)
+		}
+	}
+	// The divisor is placed in the scratch register first, in case it is in the a or d register
+	if !((divisor.T == REGISTER) && (divisor.Value == scratch)) {
+		asmcode += widen(scratch, divisor, signed) + "\t\t; divisor\n"
+	}
+	if reg != a {
+		asmcode += widen(a, n.Register, signed) + "\t\t; dividend\n"
+	}
+	asmcode += extend
+	asmcode += "\t" + divide + " " + scratch + "\t\t\t; " + a + " = " + d + ":" + a + " / " + scratch + "\n"
+	if remainder {
+		if full != d {
+			asmcode += "\tmov " + reg + ", " + registerOfSize("dx", registerBits(reg)) + "\t\t; " + reg + " = remainder\n"
+		}
+	} else if full != a {
+		asmcode += "\tmov " + reg + ", " + registerOfSize("ax", registerBits(reg)) + "\t\t; " + reg + " = quotient\n"
+	} else {
+		asmcode += "\t\t\t\t; remainder is in " + d + "\n"
+	}
+	for i := len(saved) - 1; i >= 0; i-- {
+		asmcode += "\tpop " + saved[i] + "\t\t\t; restore " + saved[i] + "\n"
+	}
+	return asmcode, nil
+}
+
+// saveRegisters returns code for saving the given registers, and code for restoring them again
+func saveRegisters(regs []string) (string, string) {
+	save, restore := "", ""
+	for i, r := range regs {
+		save += "\tpush " + r + "\t\t\t; save " + r + "\n"
+		restore += "\tpop " + regs[len(regs)-1-i] + "\t\t\t; restore " + regs[len(regs)-1-i] + "\n"
+	}
+	return save, restore
+}
+
+// restoreAL returns code for restoring al, but not ah, after the full a register and then the given
+// scratch register have been saved. The saved a register is popped into the scratch register first.
+func restoreAL(fullScratch, scratch string) string {
+	return "\tpop " + fullScratch + "\t\t\t; saved a register\n" +
+		"\tmov al, " + scratch + "\t\t; restore al\n" +
+		"\tpop " + fullScratch + "\t\t\t; restore " + fullScratch + "\n"
+}
+
+// byteOperand checks that the given operand can be used together with an 8-bit register
+func byteOperand(st Statement, operand Token) error {
+	if (operand.T == REGISTER) && (registerBits(operand.Value) != 8) {
+		return st.errorf(2, "%s must be an 8-bit register when used together with %s", operand.Value, st[0].Value)
+	}
+	if operand.T == VALUE && numbits(operand.Value) > 8 {
+		return st.errorf(2, "value does not fit in 8 bits")
+	}
+	return nil
+}
+
+// divideBytes divides an 8-bit register by a register, value or memory expression, as signed or unsigned numbers,
+// and places either the quotient or the remainder in the register. The ax register is used for the division,
+// and is saved and restored unless the register is al. If the register is ah, only al is restored.
+func (config *TargetConfig) divideBytes(n *Operation, signed, remainder bool) (string, error) {
+	st := n.st
+	reg, divisor := n.Register.Value, n.Operand
+	if err := byteOperand(st, divisor); err != nil {
+		return "", err
+	}
+	if number, err := divisor.Number(); (err == nil) && (number == 0) {
+		return "", st.errorf(2, "division by zero")
+	}
+	bits := config.PlatformBits
+	full := registerOfSize(reg, bits)
+	fullA := registerOfSize("ax", bits)
+	scratch := "cl" // a register for the divisor, that is not the one being divided
+	if full == registerOfSize("cx", bits) {
+		scratch = "bl"
+	}
+	saved := []string{registerOfSize(scratch, bits)}
+	if reg != "al" {
+		saved = append(saved, fullA)
+	}
+	save, restore := saveRegisters(saved)
+	if reg == "ah" {
+		restore = restoreAL(saved[0], scratch)
+	}
+	divide, extend, kind, what := "div", "\txor ah, ah\t\t; ah = 0, for dividing unsigned numbers\n", "unsigned", "division"
+	if signed {
+		divide, extend, kind = "idiv", "\tcbw\t\t\t; sign extend al into ah\n", "signed"
+	}
+	result := "al"
+	if remainder {
+		result, what = "ah", "modulo"
+	}
+	asmcode := "\n\t;--- " + kind + " " + what + ": " + reg + " " + st[1].Value + " " + divisor.Value + " ---\n" + save
+	// The divisor is placed in the scratch register first, in case it is in al or ah
+	if divisor.Value != scratch {
+		asmcode += "\tmov " + scratch + ", " + divisor.Value + "\t\t; divisor\n"
+	}
+	if reg != "al" {
+		asmcode += "\tmov al, " + reg + "\t\t; dividend\n"
+	}
+	asmcode += extend
+	asmcode += "\t" + divide + " " + scratch + "\t\t\t; al = ax / " + scratch + ", ah = ax % " + scratch + "\n"
+	if reg != result {
+		asmcode += "\tmov " + reg + ", " + result + "\t\t; " + reg + " = " + map[bool]string{false: "quotient", true: "remainder"}[remainder] + "\n"
+	}
+	return asmcode + restore, nil
+}
+
+// multiplyWithA multiplies a register by a register, value or memory expression, by using the one-operand
+// mul instruction, which multiplies the a register. This works on all x86 processors, and with 8-bit registers.
+// The high half of the result ends up in the d register, or in ah for 8-bit registers. The a and d registers,
+// and the register that holds the multiplier, are saved and restored if they are not the one being multiplied.
+// If ah is multiplied, only al is restored.
+func (config *TargetConfig) multiplyWithA(n *Operation) (string, error) {
+	st := n.st
+	reg, operand := n.Register.Value, n.Operand
+	bits, platformBits := registerBits(reg), config.PlatformBits
+	if bits > platformBits {
+		return "", st.errorf(0, "%s is too large for a %d-bit platform", reg, platformBits)
+	}
+	if bits == 8 {
+		if err := byteOperand(st, operand); err != nil {
+			return "", err
+		}
+	} else if (operand.T == REGISTER) && (registerBits(operand.Value) != bits) {
+		return "", st.errorf(2, "%s and %s must be of the same size", reg, operand.Value)
+	}
+	var (
+		full       = registerOfSize(reg, platformBits)
+		fullA      = registerOfSize("ax", platformBits)
+		fullD      = registerOfSize("dx", platformBits)
+		a          = registerOfSize("ax", bits)
+		high       = registerOfSize("dx", bits)
+		multiplier = operand.Value
+		saved      []string
+	)
+	if bits == 8 {
+		high = "ah"
+	}
+	// A register is needed for the multiplier if it is a value or memory expression,
+	// or if it is in the a register, which is about to be changed. When multiplying ah, the
+	// register is also needed for restoring al afterwards.
+	if (operand.T != REGISTER) || ((registerOfSize(operand.Value, platformBits) == fullA) && (operand.Value != reg)) || (reg == "ah") {
+		multiplier = registerOfSize("cx", bits)
+		if full == registerOfSize("cx", platformBits) {
+			multiplier = registerOfSize("bx", bits)
+		}
+		saved = append(saved, registerOfSize(multiplier, platformBits))
+	}
+	if (full != fullA) || (reg == "ah") {
+		saved = append(saved, fullA)
+	}
+	if (bits != 8) && (full != fullD) && (full != fullA) {
+		saved = append(saved, fullD)
+	}
+	save, restore := saveRegisters(saved)
+	if reg == "ah" {
+		restore = restoreAL(saved[0], multiplier)
+	}
+	asmcode := "\n\t;--- multiplication: " + reg + " " + st[1].Value + " " + operand.Value + " ---\n" + save
+	if multiplier != operand.Value {
+		asmcode += "\tmov " + multiplier + ", " + operand.Value + "\t\t; multiplier\n"
+	}
+	if reg != a {
+		asmcode += "\tmov " + a + ", " + reg + "\t\t; multiplicand\n"
+	}
+	asmcode += "\tmul " + multiplier + "\t\t\t; " + high + ":" + a + " = " + a + " * " + multiplier + "\n"
+	if reg != a {
+		asmcode += "\tmov " + reg + ", " + a + "\t\t; " + reg + " = product\n"
+	} else {
+		asmcode += "\t\t\t\t; the high half of the product is in " + high + "\n"
+	}
+	return asmcode + restore, nil
+}
+
+// genListStore assigns to an element of a list, like funparam[1] = rax
+func (config *TargetConfig) genListStore(n *ListStore, ps *ProgramState) (string, error) {
+	st := n.st
+	reg, err := config.reservedAndValue(st[:2])
+	if err != nil {
+		return "", err
+	}
+	retval := "\tmov " + reg + ", " + st[3].Value + "\t\t\t; "
+	if (config.PlatformBits == 32) && (st[3].T != REGISTER) {
+		retval = strings.Replace(retval, "mov", "mov DWORD", 1)
+	}
+	pointercomment := ""
+	if st[3].T == VALIDNAME {
+		pointercomment = "&"
+	}
+	retval += fmt.Sprintf("%s[%s] = %s%s\n", st[0].Value, st[1].Value, pointercomment, st[3].Value)
+	return retval, nil
+}
+
+// genListLoad assigns an element of a list to a register, like rax = funparam[1]
+func (config *TargetConfig) genListLoad(n *ListLoad, ps *ProgramState) (string, error) {
+	st := n.st
+	reg, err := config.reservedAndValue(st[2:])
+	if err != nil {
+		return "", err
+	}
+	retval := "\tmov " + st[0].Value + ", " + reg + "\t\t\t; "
+	retval += fmt.Sprintf("%s = %s[%s]\n", st[0].Value, st[2].Value, st[3].Value)
+	return retval, nil
+}
+
+// genListCopy copies an element of a list to an element of another list
+func (config *TargetConfig) genListCopy(n *ListCopy, ps *ProgramState) (string, error) {
+	st := n.st
+	to, err := config.reservedAndValue(st[:2])
+	if err != nil {
+		return "", err
+	}
+	from, err := config.reservedAndValue(st[3:])
+	if err != nil {
+		return "", err
+	}
+	retval := ""
+	if config.PlatformBits != 32 {
+		retval = "\tmov " + to + ", " + from + "\t\t\t; "
+	} else {
+		retval = "\tmov eax, " + from + "\t\t\t; Uses eax as a temporary variable\n"
+		retval += "\tmov " + to + ", ebx\t\t\t; "
+	}
+	retval += fmt.Sprintf("%s[%s] = %s[%s]\n", st[0].Value, st[1].Value, st[3].Value, st[4].Value)
+	return retval, nil
+}
+
+// genAsmPassthrough outputs inline assembly for the given platform
+func (config *TargetConfig) genAsmPassthrough(n *AsmPassthrough, ps *ProgramState) (string, error) {
+	st := n.st
+	if config.PlatformBits == n.Bits {
+		// Add the rest of the line as a regular assembly expression
+		if len(st) == 7 {
+			comma1 := " "
+			comma2 := ", "
+			if st[4].T == QUAL {
+				comma1 = ", "
+				comma2 = " "
+			}
+			// with address calculations
+			if strings.Contains(st[5].Value, "+") || strings.Contains(st[5].Value, "-") {
+				return "\t" + st[2].Value + " " + st[3].Value + " " + st[4].Value + " " + st[5].Value + " " + st[6].Value + "\t\t\t; asm with address calculation\n", nil
+			} else if strings.HasPrefix(st[2].Value, "i") {
+				comma1 = ", "
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + " " + st[6].Value + "\t\t\t; asm with integer maths\n", nil
+			} else {
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + " " + st[6].Value + "\t\t\t; asm with floating point instructions\n", nil
+			}
+		} else if len(st) == 6 {
+			comma1 := " "
+			comma2 := ", "
+			if st[4].T == QUAL {
+				comma1 = ", "
+				comma2 = " "
+			}
+			// with address calculations
+			if strings.Contains(st[5].Value, "+") || strings.Contains(st[5].Value, "-") {
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + "\t\t\t; asm with address calculation\n", nil
+			} else if strings.HasPrefix(st[2].Value, "i") {
+				comma1 = ", "
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + "\t\t\t; asm with integer maths\n", nil
+			} else {
+				return "\t" + st[2].Value + " " + st[3].Value + comma1 + st[4].Value + comma2 + st[5].Value + "\t\t\t; asm with floating point instructions\n", nil
+			}
+		} else if len(st) == 5 {
+			comma2 := ", "
+			if (st[3].T == QUAL) || (st[3].Value == "equ") {
+				// a qualifier like "byte", or a constant definition like "SYS_FLY equ 99"
+				comma2 = " "
+			}
+			// with address calculations
+			if strings.Contains(st[4].Value, "+") || strings.Contains(st[4].Value, "-") {
+				return "\t" + st[2].Value + " " + st[3].Value + comma2 + st[4].Value + "\t\t\t; asm with address calculation\n", nil
+			} else if st[3].Value == "st" {
+				return "\t" + st[2].Value + " " + st[3].Value + " (" + st[4].Value + ")\t\t\t; asm\n", nil
+			} else {
+				return "\t" + st[2].Value + " " + st[3].Value + comma2 + st[4].Value + "\t\t\t; asm\n", nil
+			}
+		} else if len(st) == 4 {
+			return "\t" + st[2].Value + " " + st[3].Value + "\t\t\t; asm\n", nil
+		} else if len(st) == 3 {
+			// a label or keyword like "stosb"
+			if strings.Contains(st[2].Value, ":") {
+				return "\t" + st[2].Value + "\t\t\t; asm label\n", nil
+			}
+			return "\t" + st[2].Value + "\t\t\t; asm\n", nil
+		}
+		return "", st.errorf(2, "unrecognized length of assembly expression: %d", len(st)-2)
+	}
+	// Not the target bits, skip
+	return "", nil
+}
+
+// genFunDecl starts a function
+func (config *TargetConfig) genFunDecl(n *FunDecl, ps *ProgramState) (string, error) {
+	st := n.st
+	if ps.inFunction != "" {
+		return "", st.errorf(1, "missing \"ret\" or \"end\"? Already in a function named %s when declaring function %s", ps.inFunction, n.Name)
+	}
+	asmcode := ";--- function " + n.Name + " ---\n"
+	ps.inFunction = n.Name
+	ps.frame = newFrame()
+	ps.pastLocals = false
+	if err := config.checkParams(n, ps); err != nil {
+		return "", err
+	}
+	ps.frame.bind(n.Params)
+	// Store the name of the declared function in defined_names
+	if has(ps.definedNames, ps.inFunction) {
+		return "", st.errorf(1, "can not declare function, name is already defined: %s", ps.inFunction)
+	}
+	ps.definedNames = append(ps.definedNames, ps.inFunction)
+	if config.PlatformBits != 16 {
+		asmcode += "global " + ps.inFunction + "\t\t\t; make label available to the linker\n"
+	}
+	asmcode += ps.inFunction + ":\t\t\t\t; name of the function\n\n"
+	if (ps.inFunction == "main") || (ps.inFunction == config.LinkerStartFunction) {
+		//log.Println("Not setting up stack frame in the main/_start/start function.")
+		return asmcode, nil
+	}
+	switch config.PlatformBits {
+	case 64:
+		asmcode += "\t;--- setup stack frame ---\n"
+		asmcode += "\tpush rbp\t\t\t; save old base pointer\n"
+		asmcode += "\tmov rbp, rsp\t\t\t; use stack pointer as new base pointer\n"
+	case 32:
+		asmcode += "\t;--- setup stack frame ---\n"
+		asmcode += "\tpush ebp\t\t\t; save old base pointer\n"
+		asmcode += "\tmov ebp, esp\t\t\t; use stack pointer as new base pointer\n"
+	case 16:
+		if len(n.Params) > 0 {
+			// Only needed for finding the parameters, at [bp+4] and up
+			asmcode += "\t;--- setup stack frame ---\n"
+			asmcode += "\tpush bp\t\t\t\t; save old base pointer\n"
+			asmcode += "\tmov bp, sp\t\t\t; use stack pointer as new base pointer\n"
+		}
+	}
+	return asmcode, nil
+}
+
+// genCall calls a function
+func (config *TargetConfig) genCall(n *Call, ps *ProgramState) (string, error) {
+	if n.Implicit && !has(ps.definedNames, n.Name) {
+		return "", n.st.errorf(0, "no function named: %s", n.Name)
+	}
+	asmcode := "\t;--- call the \"" + n.Name + "\" function ---\n"
+	if has(ps.externs, n.Name) && (config.PlatformBits != 16) {
+		code, err := config.callC(n, ps)
+		if err != nil {
+			return "", err
+		}
+		return asmcode + code, nil
+	}
+	if len(n.Args) == 0 {
+		asmcode += "\tcall " + n.Name + "\n"
+	} else {
+		code, stackBytes, err := config.loadArguments(n)
+		if err != nil {
+			return "", err
+		}
+		asmcode += code + "\tcall " + n.Name + "\n" + config.removeArguments(stackBytes)
+	}
+	return asmcode + config.storeResult(n), nil
+}
+
+// storeResult moves the return value of a function to the register it should be stored in, if any
+func (config *TargetConfig) storeResult(n *Call) string {
+	if n.Result == nil {
+		return ""
+	}
+	if reg := registerOfSize(config.returnRegister(), registerBits(n.Result.Value)); reg != n.Result.Value {
+		return "\tmov " + n.Result.Value + ", " + reg + "\t\t\t; store the return value\n"
+	}
+	return ""
+}
+
+// loadArguments passes the arguments to a function in the way the calling convention of the target describes.
+// Arguments that do not fit in registers are pushed in reverse order, and the number of bytes
+// that must be removed from the stack by the caller after the call is returned, including any shadow space.
+func (config *TargetConfig) loadArguments(n *Call) (string, int, error) {
+	var (
+		asmcode  string
+		cc       = config.callingConvention()
+		inRegs   = n.Args
+		onStack  []Token
+		numRegs  = len(cc.intRegisters)
+		argument = func(i int) string { return "\t\t\t; argument " + strconv.Itoa(i+1) + "\n" }
+	)
+	if len(n.Args) > numRegs {
+		inRegs, onStack = n.Args[:numRegs], n.Args[numRegs:]
+	}
+	// Local variables that are smaller than a register are zero extended into a scratch register before being pushed
+	scratch := config.scratchRegister(n.Args)
+	push := func(arg Token, i int) string {
+		if code := config.extendLocal(scratch, arg); code != "" {
+			return code + argument(i) + "\tpush " + scratch + "\n"
+		}
+		return "\tpush " + config.argument(arg) + argument(i)
+	}
+	for i := len(onStack) - 1; i >= 0; i-- {
+		asmcode += push(onStack[i], numRegs+i)
+	}
+	// Registers and memory are pushed and then popped into the parameter registers,
+	// so that no argument is overwritten before it has been used
+	var pushed []int
+	for i, arg := range inRegs {
+		if (arg.T == REGISTER) || (arg.T == MEMEXP) {
+			pushed = append(pushed, i)
+		}
+	}
+	if len(pushed) == 1 {
+		// Only one argument, it can be moved directly
+		if i := pushed[0]; config.extendLocal(cc.intRegisters[i], inRegs[i]) != "" {
+			asmcode += config.extendLocal(cc.intRegisters[i], inRegs[i]) + argument(i)
+		} else if cc.intRegisters[i] != inRegs[i].Value {
+			asmcode += "\tmov " + cc.intRegisters[i] + ", " + config.argument(inRegs[i]) + argument(i)
+		}
+		pushed = nil
+	}
+	for _, i := range pushed {
+		asmcode += push(inRegs[i], i)
+	}
+	for j := len(pushed) - 1; j >= 0; j-- {
+		asmcode += "\tpop " + cc.intRegisters[pushed[j]] + "\n"
+	}
+	for i, arg := range inRegs {
+		if arg.T == VALUE {
+			asmcode += "\tmov " + cc.intRegisters[i] + ", " + arg.Value + argument(i)
+		} else if arg.T == VALIDNAME {
+			asmcode += "\t" + config.loadAddress(cc.intRegisters[i], arg.Value) + argument(i)
+		}
+	}
+	if cc.shadowSpace > 0 {
+		asmcode += "\tsub " + config.stackPointer() + ", " + strconv.Itoa(cc.shadowSpace) + "\t\t\t; shadow space for the called function\n"
+	}
+	return asmcode, len(onStack)*cc.slotSize + cc.shadowSpace, nil
+}
+
+// removeArguments removes the given number of bytes of arguments from the stack, after a call
+func (config *TargetConfig) removeArguments(stackBytes int) string {
+	if stackBytes == 0 {
+		return ""
+	}
+	return "\tadd " + config.stackPointer() + ", " + strconv.Itoa(stackBytes) + "\t\t\t; remove the arguments from the stack\n"
+}
+
+// callC calls an external function, like printf from libc, following the calling convention of the target, which is
+// the System V AMD64 ABI or the Microsoft x64 calling convention on 64-bit, and cdecl on 32-bit.
+// On Win32, external functions are Windows API functions, like MessageBoxA, which use stdcall and have decorated names.
+// The stack is aligned to 16 bytes for the call, and the caller-saved registers are preserved,
+// except for the register that the return value ends up in.
+func (config *TargetConfig) callC(n *Call, ps *ProgramState) (string, error) {
+	ret := config.returnRegister()
+	result := ret
+	if n.Result != nil {
+		result = registerOfSize(n.Result.Value, config.PlatformBits)
+	}
+	var saved []string
+	for _, reg := range config.callerSavedRegisters() {
+		if (reg != result) && ((reg != ret) || (n.Result != nil)) {
+			saved = append(saved, reg)
+		}
+	}
+	asmcode := ""
+	for _, reg := range saved {
+		asmcode += "\tpush " + reg + "\t\t\t; save caller-saved register\n"
+	}
+	args, stackBytes, err := config.loadArguments(n)
+	if err != nil {
+		return "", err
+	}
+	sp := config.stackPointer()
+	word := sizeQualifiers[config.PlatformBits/8]
+	// Store the stack pointer twice, so that it can be restored no matter how much the alignment moves it
+	asmcode += "\tpush " + sp + "\t\t\t; save the stack pointer\n"
+	asmcode += "\tpush " + word + " [" + sp + "]\n"
+	asmcode += "\tand " + sp + ", -16\t\t\t; align the stack to 16 bytes\n"
+	// The stack must also be aligned after the arguments have been pushed
+	padding := (16 - stackBytes%16) % 16
+	if padding > 0 {
+		asmcode += "\tsub " + sp + ", " + strconv.Itoa(padding) + "\t\t\t; keep the stack aligned after pushing the arguments\n"
+	}
+	asmcode += args
+	if (config.PlatformBits == 64) && (config.OS != Windows) {
+		asmcode += "\txor eax, eax\t\t\t; no vector registers are used for variable arguments\n"
+	}
+	if (config.OS == Windows) && (config.PlatformBits == 32) {
+		// The called function removes its own arguments from the stack, but not the padding
+		name := config.windowsFunction(n.Name, stackBytes)
+		asmcode = config.importFunction(name, ps) + asmcode
+		asmcode += "\tcall " + name + "\n" + config.removeArguments(padding)
+	} else {
+		asmcode += "\tcall " + n.Name + "\n" + config.removeArguments(stackBytes+padding)
+	}
+	asmcode += "\tmov " + sp + ", [" + sp + "+" + strconv.Itoa(config.PlatformBits/8) + "]\t\t; restore the stack pointer\n"
+	asmcode += config.storeResult(n)
+	for i := len(saved) - 1; i >= 0; i-- {
+		asmcode += "\tpop " + saved[i] + "\t\t\t\t; restore caller-saved register\n"
+	}
+	return asmcode, nil
+}
+
+// argument returns the given argument in a form that can be pushed to the stack
+func (config *TargetConfig) argument(arg Token) string {
+	switch arg.T {
+	case REGISTER:
+		return registerOfSize(arg.Value, config.PlatformBits)
+	case MEMEXP:
+		// A local variable or parameter, pushed as a whole word, since smaller sizes can not be pushed
+		return sizeQualifiers[config.PlatformBits/8] + " " + arg.Value
+	case VALUE:
+		return sizeQualifiers[config.PlatformBits/8] + " " + arg.Value
+	}
+	return arg.Value
+}
+
+// extendLocal returns code for zero extending a local variable that is smaller than a register into the given register,
+// or an empty string if the argument is not such a local variable
+func (config *TargetConfig) extendLocal(reg string, arg Token) string {
+	bits := qualifierBits(arg.extra)
+	if (arg.T != MEMEXP) || (bits == 0) || (bits >= config.PlatformBits) {
+		return ""
+	}
+	if bits == 32 {
+		// Moving to a 32-bit register clears the upper half of the 64-bit register
+		return "\tmov " + registerOfSize(reg, 32) + ", " + arg.extra + " " + arg.Value
+	}
+	return "\tmovzx " + reg + ", " + arg.extra + " " + arg.Value
+}
+
+// scratchRegister returns a register that the called function may change anyway and that is not one of the arguments
+func (config *TargetConfig) scratchRegister(args []Token) string {
+	regs := config.callerSavedRegisters()
+	for i := len(regs) - 1; i >= 0; i-- {
+		used := false
+		for _, arg := range args {
+			if (arg.T == REGISTER) && (registerOfSize(arg.Value, config.PlatformBits) == regs[i]) {
+				used = true
+			}
+		}
+		if !used {
+			return regs[i]
+		}
+	}
+	return regs[0]
+}
+
+// checkParams checks that the parameters of a function have unique names, and that the function can take parameters
+func (config *TargetConfig) checkParams(n *FunDecl, ps *ProgramState) error {
+	if len(n.Params) == 0 {
+		return nil
+	}
+	if (n.Name == "main") || (n.Name == config.LinkerStartFunction) {
+		return n.st.errorf(2, "the %s function can not have parameters", n.Name)
+	}
+	for i, name := range n.Params {
+		if has(n.Params[:i], name) || has(ps.definedNames, name) {
+			return n.st.errorf(2+i, "can not declare parameter, name is already defined: %s", name)
+		}
+	}
+	return nil
+}
+
+// genCounter sets the loop counter
+func (config *TargetConfig) genCounter(n *Counter, ps *ProgramState) (string, error) {
+	return "\tmov " + config.counterRegister() + ", " + n.Value.Value + "\t\t\t; set (loop) counter\n", nil
+}
+
+// genSetValue sets the value that is written by write and loopwrite
+func (config *TargetConfig) genSetValue(n *SetValue, ps *ProgramState) (string, error) {
+	st := n.st
+	asmcode := ""
+	switch config.PlatformBits {
+	case 64:
+		asmcode = "\tmov rax, " + st[1].Value + "\t\t\t; set value, in preparation for looping\n"
+		ps.loopStep = 8
+	case 32:
+		asmcode = "\tmov eax, " + st[1].Value + "\t\t\t; set value, in preparation for looping\n"
+		ps.loopStep = 4
+	case 16:
+		// Find out if the value is a byte or a word, then set a global variable to keep track of if the nest loop should be using stosb or stosw
+		if st[1].T == VALUE {
+			switch bits := numbits(st[1].Value); {
+			case bits > 16:
+				return "", st.errorf(1, "value does not fit in a word: %s", st[1].Value)
+			case bits > 8:
+				asmcode += "\tmov ax, " + st[1].Value + "\t\t\t; set value, in preparation for stosw\n"
+				ps.loopStep = 2
+			default:
+				asmcode += "\tmov al, " + st[1].Value + "\t\t\t; set value, in preparation for stosb\n"
+				ps.loopStep = 1
+			}
+		} else if st[1].T == REGISTER {
+			switch st[1].Value {
+			// TODO: Introduce a function for checking if a register is 8-bit, 16-bit, 32-bit or 64-bit
+			case "al", "ah", "bl", "bh", "cl", "ch", "dl", "dh":
+				asmcode += "\tmov al, " + st[1].Value + "\t\t\t; set value from register, in preparation for stosb\n"
+				ps.loopStep = 1
+			default:
+				asmcode += "\tmov ax, " + st[1].Value + "\t\t\t; Set value from register, in preparation for stosw\n"
+				ps.loopStep = 2
+			}
+		} else {
+			return "", st.errorf(1, "unable to tell if this is a word or a byte: %s", st[1].Value)
+		}
+	default:
+		return "", st.errorf(0, "unimplemented: the %s keyword for %d bit platforms", st[0].Value, config.PlatformBits)
+	}
+	return asmcode, nil
+}
+
+// genLoopWrite writes a value counter times
+func (config *TargetConfig) genLoopWrite(n *LoopWrite, ps *ProgramState) (string, error) {
+	asmcode := ""
+	switch config.PlatformBits {
+	case 16:
+		if ps.loopStep == 2 {
+			asmcode += "\trep stosw\t\t\t; write the value in ax, cx times, starting at es:di\n"
+		} else { // if ps.loop_step == 1 {
+			asmcode += "\trep stosb\t\t\t; write the value in al, cx times, starting at es:di\n"
+		}
+	default:
+		asmcode += "\tcld\n\trep stosb\t\t\t; write the value in eax/rax, ecx/rcx times, starting at edi/rdi\n"
+	}
+	return asmcode, nil
+}
+
+// genWrite writes a value once
+func (config *TargetConfig) genWrite(n *Write, ps *ProgramState) (string, error) {
+	st := n.st
+	asmcode := ""
+	switch config.PlatformBits {
+	case 16:
+		if ps.loopStep == 2 {
+			asmcode += "\tstosw\t\t\t; write the value in ax, starting at es:di\n"
+		} else { // if ps.loop_step == 1 {
+			asmcode += "\tstosb\t\t\t; write the value in al, starting at es:di\n"
+		}
+		//else log.Fatalln("Error: Unrecognized step size. Defaulting to 1.")
+	default:
+		return "", st.errorf(0, "unimplemented: the %s keyword for %d bit platforms", st[0].Value, config.PlatformBits)
+	}
+	return asmcode, nil
+}
+
+// genLoop starts a loop
+func (config *TargetConfig) genLoop(n *Loop, ps *ProgramState) (string, error) {
+	// TODO: Make every instruction and call declare which registers they will change. This allows for better use of the registers.
+
+	// The start of a rawloop or loop, that have an optional counter value and ends with "end"
+	rawloop := n.Raw
+	hascounter := (n.Count != nil)
+	endlessloop := !rawloop && !hascounter
+
+	// Find a suitable label
+	label := ""
+	if rawloop {
+		label = rawloopPrefix + ps.newLoopLabel()
+	} else {
+		if endlessloop {
+			label = endlessloopPrefix + ps.newLoopLabel()
+		} else {
+			label = ps.newLoopLabel()
+		}
+	}
+
+	// Now in the loop
+	ps.pushBlock(&block{kind: loopBlock, label: label, name: n.Name})
+
+	asmcode := ""
+
+	// Initialize the loop, if it was given a number
+	if !hascounter {
+		asmcode += "\t;--- loop ---\n"
+	} else {
+		if endlessloop {
+			asmcode += "\t;--- endless loop ---\n"
+		} else {
+			asmcode += "\t;--- loop " + n.Count.Value + " times ---\n"
+			asmcode += "\tmov " + config.counterRegister() + ", " + n.Count.Value
+			asmcode += "\t\t\t; initialize loop counter\n"
+		}
+	}
+	asmcode += label + ":\t\t\t\t\t; start of loop " + label + "\n"
+
+	// If it's not a raw loop (or endless loop), take care of the counter
+	if (!rawloop) && (!endlessloop) {
+		asmcode += "\tpush " + config.counterRegister() + "\t\t\t; save the counter\n"
+	}
+	return asmcode, nil
+}
+
+// genWhile starts a loop that runs for as long as the condition is true
+func (config *TargetConfig) genWhile(n *While, ps *ProgramState) (string, error) {
+	label := whileloopPrefix + ps.newLoopLabel()
+	ps.pushBlock(&block{kind: loopBlock, label: label, name: n.Name})
+	asmcode := "\t;--- while loop ---\n"
+	asmcode += label + ":\t\t\t\t\t; start of loop " + label + "\n"
+	asmcode += ps.skipUnless(n.Cond, label+"_end", "done")
+	return asmcode, nil
+}
+
+// genAddress sets the address that is written to by write and loopwrite
+func (config *TargetConfig) genAddress(n *Address, ps *ProgramState) (string, error) {
+	st := n.st
+	asmcode := ""
+	switch config.PlatformBits {
+	case 16:
+		segmentOffset := st[1].Value
+		if !strings.Contains(segmentOffset, ":") {
+			return "", st.errorf(1, "address takes a segment:offset value")
+		}
+		sl := strings.SplitN(segmentOffset, ":", 2)
+		if len(sl) != 2 {
+			return "", st.errorf(1, "unrecognized segment:offset address: %s", segmentOffset)
+		}
+		segment := sl[0]
+		offset := sl[1]
+		config.logf(LogCodegen, "Found segment %s and offset %s", segment, offset)
+		asmcode += "\tpush " + segment + "\t\t\t; can not mov directly into es\n"
+		asmcode += "\tpop es\t\t\t\t; segment = " + segment + "\n"
+		// TODO: Introduce a function that checks of 0, 0x0, 0x00, 0x0000 and all other variations of zero
+		if offset == "0" {
+			asmcode += "\txor di, di\t\t\t; offset = " + offset + "\n"
+		} else {
+			asmcode += "\tmov di, " + offset + "\t\t\t; di = " + offset + "\n"
+		}
+	case 32:
+		asmcode += "\tmov edi, " + st[1].Value + "\t\t\t; set address/offset\n"
+	case 64:
+		asmcode += "\tmov rdi, " + st[1].Value + "\t\t\t; set address/offset\n"
+	default:
+		return "", st.errorf(0, "unimplemented: the %s keyword for %d bit platforms", st[0].Value, config.PlatformBits)
+	}
+	return asmcode, nil
+}
+
+// genBootable outputs the multiboot header
+func (config *TargetConfig) genBootable(n *Bootable, ps *ProgramState) (string, error) {
+	ps.bootableKernel = true
+	// This program is supposed to be bootable
+	return `
+; Thanks to http://wiki.osdev.org/Bare_Bones_with_NASM
+
+; Declare constants used for creating a multiboot header.
+MBALIGN     equ  1<<0                   ; align loaded modules on page boundaries
+MEMINFO     equ  1<<1                   ; provide memory map
+FLAGS       equ  MBALIGN | MEMINFO      ; this is the Multiboot 'flag' field
+MAGIC       equ  0x1BADB002             ; 'magic number' lets bootloader find the header
+CHECKSUM    equ -(MAGIC + FLAGS)        ; checksum of above, to prove we are multiboot
+
+; Declare a header as in the Multiboot Standard. We put this into a special
+; section so we can force the header to be in the start of the final program.
+; You don't need to understand all these details as it is just magic values that
+; is documented in the multiboot standard. The bootloader will search for this
+; magic sequence and recognize us as a multiboot kernel.
+section .multiboot
+align 4
+dd MAGIC
+dd FLAGS
+dd CHECKSUM
+
+; Currently the stack pointer register (esp) points at anything and using it may
+; cause massive harm. Instead, we'll provide our own stack. We will allocate
+; room for a small temporary stack by creating a symbol at the bottom of it,
+; then allocating 16384 bytes for it, and finally creating a symbol at the top.
+section .bootstrap_stack
+align 4
+stack_bottom:
+times 16384 db 0
+stack_top:
+
+section .text
+`, nil
+	//'
+}
+
+// genExtern declares an external symbol
+func (config *TargetConfig) genExtern(n *Extern, ps *ProgramState) (string, error) {
+	st := n.st
+	if st[1].T == VALIDNAME {
+		extname := st[1].Value
+		// Declare the external name
+		if has(ps.definedNames, extname) {
+			return "", st.errorf(1, "can not declare external symbol, name is already defined: %s", extname)
+		}
+		// Store the name of the declared constant in defined_names
+		ps.definedNames = append(ps.definedNames, extname)
+		ps.externs = append(ps.externs, extname)
+		if (config.OS == Windows) && (config.PlatformBits == 32) {
+			// The decorated name, like _MessageBoxA@16, depends on the arguments, and is declared when it is called
+			return "\t\t\t\t; " + extname + " is a Windows API function\n", nil
+		}
+		// Return a comment
+		return "extern " + extname + "\t\t\t; external symbol\n", nil
+	}
+	return "", st.errorf(1, "extern with invalid name: %s", st[1].Value)
+}
+
+// loopToJumpTo finds the loop that break or continue should jump out of or to the top of,
+// and returns it together with the code for restoring the counters of all the loops that are left.
+// The counter of the loop that is found is also restored, since it is saved again at the top of the loop.
+func (config *TargetConfig) loopToJumpTo(st Statement, name string, ps *ProgramState) (*block, string, error) {
+	i := ps.findLoop(name)
+	if i == -1 {
+		if name != "" {
+			return nil, "", st.errorf(1, "not in a loop named %s", name)
+		}
+		return nil, "", st.errorf(0, "not in a loop, nothing to %s", st[0].Value)
+	}
+	asmcode := ""
+	for j := len(ps.blocks) - 1; j >= i; j-- {
+		if ps.blocks[j].savesCounter() {
+			asmcode += "\tpop " + config.counterRegister() + "\t\t\t\t; restore counter\n"
+		}
+	}
+	return ps.blocks[i], asmcode, nil
+}
+
+// compareAndJump compares and jumps to the given label if the comparison is true
+func compareAndJump(cond Comparison, label, comment string) string {
+	asmcode := "\tcmp " + cond.Left.Value + ", " + cond.Right.Value + "\t\t\t; compare\n"
+	asmcode += "\t" + jumpIf(cond.Op) + " " + label + "\t\t\t; " + comment + "\n"
+	return asmcode
+}
+
+// skipUnless returns code that jumps to the given label if the condition is false.
+// The comparisons are short-circuited, so that no more comparisons than needed are made.
+func (ps *ProgramState) skipUnless(cond Condition, label, comment string) string {
+	asmcode := ""
+	taken := "" // where to jump when one of the groups of comparisons joined by || is true
+	for i, group := range cond.Any {
+		if i == len(cond.Any)-1 {
+			// The last group decides
+			for _, c := range group {
+				asmcode += compareAndSkip(c, label, comment)
+			}
+			break
+		}
+		if taken == "" {
+			taken = ps.newSkipLabel()
+		}
+		next := "" // where to jump when this group is false
+		for j, c := range group {
+			if j == len(group)-1 {
+				asmcode += compareAndJump(c, taken, "true")
+				break
+			}
+			if next == "" {
+				next = ps.newSkipLabel()
+			}
+			asmcode += compareAndSkip(c, next, "false, try the next comparison")
+		}
+		if next != "" {
+			asmcode += next + ":\n"
+		}
+	}
+	if taken != "" {
+		asmcode += taken + ":\n"
+	}
+	return asmcode
+}
+
+// single returns the comparison, if the condition consists of just one
+func (cond *Condition) single() (Comparison, bool) {
+	if (cond == nil) || (len(cond.Any) != 1) || (len(cond.Any[0]) != 1) {
+		return Comparison{}, false
+	}
+	return cond.Any[0][0], true
+}
+
+// conditionally returns code that only runs the given code if the condition is true
+func (ps *ProgramState) conditionally(cond *Condition, asmcode string) string {
+	if cond == nil {
+		return asmcode
+	}
+	label := ps.newSkipLabel()
+	return ps.skipUnless(*cond, label, "skip") + asmcode + label + ":\n"
+}
+
+// genBreak breaks out of a loop, if the optional condition is true
+func (config *TargetConfig) genBreak(n *Break, ps *ProgramState) (string, error) {
+	b, asmcode, err := config.loopToJumpTo(n.st, n.Label, ps)
+	if err != nil {
+		return "", err
+	}
+	if c, ok := n.Cond.single(); ok && (asmcode == "") {
+		// No counters to restore, so a conditional jump is enough
+		return compareAndJump(c, b.label+"_end", "break"), nil
+	}
+	asmcode += "\tjmp " + b.label + "_end\t\t\t; break\n"
+	return ps.conditionally(n.Cond, asmcode), nil
+}
+
+// genContinue jumps to the top of a loop, if the optional condition is true
+func (config *TargetConfig) genContinue(n *Continue, ps *ProgramState) (string, error) {
+	b, asmcode, err := config.loopToJumpTo(n.st, n.Label, ps)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(b.label, endlessloopPrefix) || strings.HasPrefix(b.label, whileloopPrefix) {
+		// Endless loops and while loops are continued by just jumping to the top
+		if c, ok := n.Cond.single(); ok && (asmcode == "") {
+			return compareAndJump(c, b.label, "continue"), nil
+		}
+		asmcode += "\tjmp " + b.label + "\t\t\t; continue\n"
+		return ps.conditionally(n.Cond, asmcode), nil
+	}
+	// Continue looping if the counter is greater than zero
+	//asmcode += "\tloop " + in_loop + "\t\t\t; continue\n"
+	// loop can only jump <= 127 bytes away. Using dec and jnz instead
+	asmcode += "\tdec " + config.counterRegister() + "\t\t\t\t; decrease counter\n"
+	asmcode += "\tjnz " + b.label + "\t\t\t; continue if not zero\n"
+	// If the counter is zero after restoring the counter, jump out of the loop
+	asmcode += "\tjmp " + b.label + "_end\t\t\t; jump out if the loop is done\n"
+	return ps.conditionally(n.Cond, asmcode), nil
+}
+
+// genEndless marks the program as never returning
+func (config *TargetConfig) genEndless(n *Endless, ps *ProgramState) (string, error) {
+	//ps.in_loop = ""
+	//ps.in_function = ""
+	ps.endless = true
+	return "; there is no return\n", nil
+}
+
+// genEnd ends an if block, a loop or a function
+func (config *TargetConfig) genEnd(n *End, ps *ProgramState) (string, error) {
+	st := n.st
+	if ps.inlineC {
+		ps.inlineC = false
+		return "; end of inline C block\n", nil
+	} else if b := ps.innermostBlock(); (b != nil) && (b.kind == ifBlock) {
+		// End the if block
+		ps.popBlock()
+		if b.done == "" {
+			return b.next + ":\t\t\t\t; end of if block " + b.label + "\n", nil
+		}
+		asmcode := ""
+		if b.next != "" {
+			asmcode += b.next + ":\t\t\t\t; no comparison was true\n"
+		}
+		asmcode += b.done + ":\t\t\t\t; end of if block " + b.label + "\n"
+		return asmcode, nil
+	} else if b != nil {
+		// End the loop
+		ps.popBlock()
+		asmcode := ""
+		if b.savesCounter() {
+			asmcode += "\tpop " + config.counterRegister() + "\t\t\t\t; restore counter\n"
+		}
+		if strings.HasPrefix(b.label, endlessloopPrefix) {
+			asmcode += "\tjmp " + b.label + "\t\t\t\t; loop forever\n"
+			ps.endless = true
+		} else if strings.HasPrefix(b.label, whileloopPrefix) {
+			asmcode += "\tjmp " + b.label + "\t\t\t\t; check the condition again\n"
+		} else {
+			//asmcode += "\tloop " + in_loop + "\t\t\t\t; loop until " + config.counter_register() + " is zero\n"
+			asmcode += "\tdec " + config.counterRegister() + "\t\t\t\t; decrease counter\n"
+			asmcode += "\tjnz " + b.label + "\t\t\t\t; loop until " + config.counterRegister() + " is zero\n"
+		}
+		asmcode += b.label + "_end:\t\t\t\t; end of loop " + b.label + "\n"
+		asmcode += "\t;--- end of loop " + b.label + " ---\n"
+		return asmcode, nil
+	} else if ps.inFunction != "" {
+		// Return from the function if "end" is encountered
+		ret := Token{KEYWORD, "ret", st[0].Position, ""}
+		asmcode, err := config.genReturn(&Return{node{Statement{ret}}, false, nil}, ps)
+		// The function was ended with this "end", so no other "end" is expected
+		ps.surpriseEndingWithExit = false
+		return asmcode, err
+	} else {
+		// If the function was already ended with "exit", don't freak out when encountering an "end"
+		if !ps.surpriseEndingWithExit && !ps.endless {
+			return "", st.errorf(0, "not in a function or block of inline C, hard to tell what should be ended with \"end\"")
+		}
+		// Prepare for more surprises
+		ps.surpriseEndingWithExit = false
+		// Ignore this "end"
+		return "", nil
+	}
+}
+
+// genNoRet ends without returning
+func (config *TargetConfig) genNoRet(n *NoRet, ps *ProgramState) (string, error) {
+	return "; end without a return\n", nil
+}
+
+// genInlineC marks the start of a block of inline C
+func (config *TargetConfig) genInlineC(n *InlineC, ps *ProgramState) (string, error) {
+	ps.inlineC = true
+	return "; start of inline C block\n", nil
+}
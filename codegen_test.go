@@ -0,0 +1,444 @@
+package battlestarlib
+
+import (
+	"strings"
+	"testing"
+)
+
+// expectInOrder checks that all the expected strings are found in the assembly code, in the given order
+func expectInOrder(t *testing.T, asmcode string, expected []string) {
+	for _, s := range expected {
+		pos := strings.Index(asmcode, s)
+		if pos == -1 {
+			t.Fatalf("expected %q in:\n%s", s, asmcode)
+		}
+		asmcode = asmcode[pos+len(s):]
+	}
+}
+
+func TestIfBlocks(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun main\nrax == 1\nrbx == 2\nrcx = 1\nend\nelif rax == 2\nrcx = 2\nelse\nrcx = 3\nend\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each label must be jumped to and defined, in this order
+	expectInOrder(t, asmcode, []string{"jne if1_end", "jne if2_end", "if2_end:", "jmp if1_done", "if1_end:", "jne if1_elif1", "jmp if1_done", "if1_elif1:", "if1_done:"})
+	for _, program := range []string{"fun main\nelse\nend\n", "fun main\nrax == 1\nelse\nelif rax == 2\nend\nend\n"} {
+		if _, err := compile(config, program); err == nil {
+			t.Errorf("expected an error when compiling:\n%s", program)
+		}
+	}
+}
+
+func TestNestedLoops(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun main\nouter: loop 3\nloop 4\nbreak outer rax == 1\nbreak rbx == 2\ncontinue outer\nend\nend\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Breaking out of the outer loop must restore both counters, breaking out of the inner loop only one
+	expected := []string{"l1:", "push rcx", "l2:", "push rcx", "jne skip1", "pop rcx", "pop rcx", "jmp l1_end", "skip1:", "jne skip2", "pop rcx", "jmp l2_end", "skip2:", "pop rcx", "pop rcx", "dec rcx", "jnz l1", "pop rcx", "jnz l2", "l2_end:", "pop rcx", "jnz l1", "l1_end:"}
+	expectInOrder(t, asmcode, expected)
+	for _, program := range []string{"fun main\nbreak\nend\n", "fun main\nloop 2\ncontinue outer\nend\nend\n"} {
+		if _, err := compile(config, program); err == nil {
+			t.Errorf("expected an error when compiling:\n%s", program)
+		}
+	}
+}
+
+func TestCompoundConditions(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun main\nwhile rax < 10 && rbx != 0\nrax == 1 || rbx == 2 && rcx == 3 || rdx == 4\nrcx = 1\nend\nend\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every comparison is short-circuited to the right label
+	expected := []string{"w_l1:", "cmp rax, 10", "jge w_l1_end", "cmp rbx, 0", "je w_l1_end", "cmp rax, 1", "je skip1", "cmp rbx, 2", "jne skip2", "cmp rcx, 3", "je skip1", "skip2:", "cmp rdx, 4", "jne if1_end", "skip1:", "mov rcx, 1", "if1_end:", "jmp w_l1", "w_l1_end:"}
+	expectInOrder(t, asmcode, expected)
+	for _, program := range []string{"fun main\nwhile rax\nend\nend\n", "fun main\nrax == 1 && rbx\nend\nend\n", "fun main\nwhile rax == 1 rbx == 2\nend\nend\n"} {
+		if _, err := compile(config, program); err == nil {
+			t.Errorf("expected an error when compiling:\n%s", program)
+		}
+	}
+}
+
+func TestSignedAndUnsigned(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun main\nrbx /s= rcx\nrbx /= 4\nrbx /s= 4\nebx /u= ecx\nrdi >>s 2\nrax <u rbx\nend\nrax < rbx\nend\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{
+		"mov r8, rcx", "mov rax, rbx", "cqo", "idiv r8", "mov rbx, rax",
+		"shr rbx, 2",
+		"mov r8, 4", "cqo", "idiv r8",
+		"mov r8d, ecx", "mov eax, ebx", "xor rdx, rdx", "div r8", "mov ebx, eax",
+		"sar rdi, 2",
+		"jae if1_end",
+		"jge if2_end",
+	})
+
+	config, err = NewTargetConfig(32, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err = compile(config, "fun main\necx /s= bx\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{"push ebx", "push eax", "push edx", "movsx ebx, bx", "mov eax, ecx", "cdq", "idiv ebx", "mov ecx, eax", "pop edx", "pop eax", "pop ebx"})
+
+	for _, program := range []string{"fun main\neax /= 0\nend\n", "fun main\nal /s= bx\nend\n", "fun main\nrax /= 3\nend\n"} {
+		if _, err := compile(config, program); err == nil {
+			t.Errorf("expected an error when compiling:\n%s", program)
+		}
+	}
+}
+
+func TestRegisterOfSize(t *testing.T) {
+	tests := []struct {
+		reg  string
+		bits int
+		out  string
+	}{
+		{"rax", 32, "eax"},
+		{"al", 64, "rax"},
+		{"ebx", 16, "bx"},
+		{"cx", 8, "cl"},
+		{"rsi", 8, "sil"},
+		{"dil", 32, "edi"},
+		{"r8", 32, "r8d"},
+		{"r9d", 64, "r9"},
+	}
+	for _, test := range tests {
+		if out := registerOfSize(test.reg, test.bits); out != test.out {
+			t.Errorf("%s as %d bits: expected %s, got %s", test.reg, test.bits, test.out, out)
+		}
+	}
+}
+
+func TestModulo(t *testing.T) {
+	for _, bits := range []int{16, 32, 64} {
+		config, err := NewTargetConfig(bits, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asmcode, err := compile(config, "fun main\nbx %= 10\nax %s= cx\nbx %= 16\nexit\nend\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := registerOfSize("dx", bits)
+		expectInOrder(t, asmcode, []string{
+			"xor " + d + ", " + d, "div ", "mov bx, dx", "pop " + d,
+			"idiv ", "mov ax, dx", "pop " + d,
+			"and bx, 15",
+		})
+	}
+}
+
+func Test16BitArithmetic(t *testing.T) {
+	config, err := NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun main\nbx *= cx\nax *= 10\nbl *= 3\nbx /s= cx\nbl /= 3\ncl %= bl\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(asmcode, "imul") || strings.Contains(asmcode, "rax") || strings.Contains(asmcode, "eax") || strings.Contains(asmcode, "r8") {
+		t.Errorf("expected only 16-bit registers and instructions, got:\n%s", asmcode)
+	}
+	expectInOrder(t, asmcode, []string{
+		"push ax", "push dx", "mov ax, bx", "mul cx", "mov bx, ax", "pop dx", "pop ax",
+		"mov cx, 10", "mul cx",
+		"mov cl, 3", "mov al, bl", "mul cl", "mov bl, al",
+		"mov ax, bx", "cwd", "idiv cx", "mov bx, ax",
+		"mov cl, 3", "mov al, bl", "xor ah, ah", "div cl", "mov bl, al",
+		"mov al, cl", "xor ah, ah", "div bl", "mov cl, ah",
+	})
+}
+
+func TestHighByteArithmetic(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun main\nah *= 3\nah /= 3\nah %= bl\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// al must be restored, while ah keeps the result
+	expectInOrder(t, asmcode, []string{
+		"push rcx", "push rax", "mov cl, 3", "mov al, ah", "mul cl", "mov ah, al", "pop rcx", "mov al, cl", "pop rcx",
+		"push rcx", "push rax", "mov cl, 3", "mov al, ah", "xor ah, ah", "div cl", "mov ah, al", "pop rcx", "mov al, cl", "pop rcx",
+		"push rcx", "push rax", "mov cl, bl", "mov al, ah", "xor ah, ah", "div cl", "pop rcx", "mov al, cl", "pop rcx",
+	})
+}
+
+func TestLocals(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun f\nlocal x 8\nlocal letter 1\nlocal buf 32\nx = rdi\nletter = 65\nrax = x\nrbx = letter\nrcx += x\nend\nfun main\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{
+		"push rbp", "mov rbp, rsp", "sub rsp, 16", "and rsp, -16", "sub rsp, 32",
+		"mov QWORD [rbp-8], rdi", "mov BYTE [rbp-9], 65", "mov rax, [rbp-8]", "movzx rbx, BYTE [rbp-9]", "add rcx, [rbp-8]",
+		"mov rsp, rbp", "pop rbp", "ret",
+	})
+
+	// The size of a local is at most the size of a register on 32-bit platforms
+	config32, err := NewTargetConfig(32, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err = compile(config32, "fun f\nlocal z 8\nlocal w 2\nz = 3\nw = 1\neax = z\neax = w\nend\nfun main\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{"push ebp", "mov ebp, esp", "sub esp, 8", "sub esp, 4", "mov DWORD [ebp-8], 3", "mov WORD [ebp-10], 1", "mov eax, [ebp-8]", "movzx eax, WORD [ebp-10]"})
+	if strings.Contains(asmcode, "QWORD") {
+		t.Errorf("expected no QWORD operands on a 32-bit platform:\n%s", asmcode)
+	}
+
+	// Locals that are smaller than a register are zero extended when passed as arguments
+	asmcode, err = compile(config, "fun g(x, y)\nend\nfun f\nlocal count 4\nlocal letter 1\ncount = 7\nletter = 2\ng(count)\ng(letter, rdi)\nend\nfun main\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{"mov DWORD [rbp-4], 7", "mov edi, DWORD [rbp-4]", "call g", "movzx r11, BYTE [rbp-5]", "push r11", "push rdi", "pop rsi", "pop rdi", "call g"})
+	if strings.Contains(asmcode, "QWORD [rbp-4]") || strings.Contains(asmcode, "QWORD [rbp-5]") {
+		t.Errorf("expected narrow locals not to be read as QWORD arguments:\n%s", asmcode)
+	}
+
+	// The stack frame of main must also be taken down, in case main returns
+	asmcode, err = compile(config, "fun main\nlocal x 8\nx = 1\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{"main:", "push rbp", "mov rbp, rsp", "sub rsp, 16", "and rsp, -16", "mov QWORD [rbp-8], 1", "mov rsp, rbp", "pop rbp", "syscall"})
+
+	for _, program := range []string{"local x 8\n", "fun f\nrax = 1\nlocal x 8\nend\n", "fun f\nlocal x 8\nlocal x 4\nend\n", "fun f\nlocal x 0\nend\n"} {
+		if _, err := compile(config, program); err == nil {
+			t.Errorf("expected an error when compiling:\n%s", program)
+		}
+	}
+}
+
+func TestFunctionParameters(t *testing.T) {
+	program := "fun add(x, y)\nrax = x\nrax += y\nend\nfun main\nadd(rax, 5)\ncall add(rsi, rdi)\nexit\nend\n"
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The arguments must not be overwritten before they are used
+	expectInOrder(t, asmcode, []string{"mov rax, rdi", "add rax, rsi", "mov rdi, rax", "mov rsi, 5", "call add", "push rsi", "push rdi", "pop rsi", "pop rdi", "call add"})
+
+	config, err = NewTargetConfig(32, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err = compile(config, "fun add(x, y)\neax = x\neax += y\nend\nfun main\nadd(eax, 5)\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{"push ebp", "mov eax, [ebp+8]", "add eax, [ebp+12]", "push DWORD 5", "push eax", "call add", "add esp, 8"})
+
+	for _, program := range []string{"fun f(x, x)\nend\n", "fun main(x)\nend\n", "fun f(x)\nlocal x 8\nend\n", "fun f\nend\nfun main\ncall f(=)\nend\n"} {
+		if _, err := compile(config, program); err == nil {
+			t.Errorf("expected an error when compiling:\n%s", program)
+		}
+	}
+}
+
+func TestReturnValues(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun add(x, y)\nlocal sum 8\nrax = x\nrax += y\nsum = rax\nret sum\nfun five\nret 5\nfun main\nrbx = add(rax, 5)\nbl = call five\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The return value must be loaded before the stack frame is taken down
+	expectInOrder(t, asmcode, []string{"mov rax, [rbp-8]", "mov rsp, rbp", "ret", "mov rax, 5", "ret", "call add", "mov rbx, rax", "call five", "mov bl, al"})
+	if _, err := compile(config, "fun f\nret 1 2\n"); err == nil {
+		t.Error("expected an error for more than one return value")
+	}
+}
+
+func TestEarlyReturn(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun f(x)\nx == 0\nret 1\nend\nloop 3\nrdx == x\nret rcx\nend\nend\nret 2\nend\nfun main\nf(rax)\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Returning early must not end the function, and the loop counter must be removed from the stack
+	expectInOrder(t, asmcode, []string{
+		"f:", "cmp rdi, 0", "jne if1_end", "mov rax, 1", "mov rsp, rbp", "pop rbp", "ret", "if1_end:",
+		"l1:", "push rcx", "cmp rdx, rdi", "jne if2_end", "mov rax, rcx", "pop rcx", "mov rsp, rbp", "pop rbp", "ret", "if2_end:",
+		"pop rcx", "jnz l1", "l1_end:",
+		"mov rax, 2", "mov rsp, rbp", "pop rbp", "ret",
+		"main:", "call f",
+	})
+	if _, err := compile(config, "fun f(x)\nx == 0\nret 1\nend\nret 2\nend\nfun main\nexit\nend\n"); err != nil {
+		t.Errorf("expected ret to be followed by end: %v", err)
+	}
+}
+
+func TestCallingC(t *testing.T) {
+	program := "extern printf\nconst fmt = \"%d\", 10, 0\nfun main\nrbx = printf(fmt, rsi)\nexit\nend\n"
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{
+		"push rax", "push rcx", "push r11",
+		"push rsp", "push QWORD [rsp]", "and rsp, -16",
+		"mov rdi, fmt", "xor eax, eax", "call printf", "mov rsp, [rsp+8]",
+		"mov rbx, rax", "pop r11", "pop rcx", "pop rax",
+	})
+
+	config, err = NewTargetConfig(32, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err = compile(config, strings.Replace(program, "rbx = printf(fmt, rsi)", "call printf(fmt, ebx)", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{"push ecx", "push edx", "and esp, -16", "sub esp, 8", "push ebx", "push fmt", "call printf", "add esp, 16", "mov esp, [esp+4]", "pop edx", "pop ecx"})
+}
+
+func TestWindows(t *testing.T) {
+	program := "extern puts\nconst msg = \"Hi\", 10\nfun main\nprint(msg)\nputs(msg)\nexit 3\nend\n"
+	config, err := NewWindowsTargetConfig(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{
+		"section .text", "global main", "main:",
+		"extern GetStdHandle", "extern WriteFile", "mov rcx, -11", "call GetStdHandle", "mov rcx, rax", "mov rdx, msg", "mov r8, _length_of_msg", "call WriteFile",
+		"mov rcx, msg", "sub rsp, 32", "call puts", "add rsp, 32",
+		"extern ExitProcess", "mov rcx, 3", "sub rsp, 32", "call ExitProcess",
+	})
+	if strings.Contains(asmcode, "syscall") || strings.Contains(asmcode, "xor eax, eax") {
+		t.Errorf("expected no system calls and no System V conventions on Windows:\n%s", asmcode)
+	}
+
+	config, err = NewWindowsTargetConfig(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// External functions on Win32 are Windows API functions, which remove their own arguments from the stack
+	asmcode, err = compile(config, strings.Replace(strings.Replace(program, "puts(msg)", "MessageBoxA(0, msg, msg, 0)", 1), "extern puts", "extern MessageBoxA", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{
+		"global _main", "_main:", "main:",
+		"call _GetStdHandle@4", "push msg", "push eax", "call _WriteFile@20",
+		"extern _MessageBoxA@16", "push DWORD 0", "push msg", "push msg", "push DWORD 0", "call _MessageBoxA@16", "mov esp, [esp+4]",
+		"push DWORD 3", "call _ExitProcess@4",
+	})
+	if strings.Contains(asmcode, "add esp, 16") || strings.Contains(asmcode, "extern MessageBoxA") {
+		t.Errorf("expected stdcall for Windows API functions on Win32:\n%s", asmcode)
+	}
+	if _, err := compile(config, "fun main\nsyscall(1, 2)\nend\n"); err == nil {
+		t.Error("expected an error for system calls on Windows")
+	}
+	if _, err := NewWindowsTargetConfig(16); err == nil {
+		t.Error("expected an error for 16-bit Windows")
+	}
+}
+
+func TestMacOS64(t *testing.T) {
+	config, err := NewTargetConfig(64, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "const msg = \"Hi\", 10\nvar buf 8\nfun main\nprint(msg)\nbuf = msg\nrax = msg\nexit 3\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{
+		"default rel", "global _main", "_main:", "and rsp, -16", "main:",
+		"mov rax, 0x2000004", "lea rsi, [msg]", "mov rdx, _length_of_msg", "syscall",
+		"lea rdi, [buf]", "lea rsi, [msg]",
+		"lea rax, [msg]",
+		"mov rax, 0x2000001", "mov rdi, 3", "syscall",
+	})
+	if strings.Contains(asmcode, "push dword") {
+		t.Errorf("expected the system call arguments to be passed in registers on 64-bit macOS:\n%s", asmcode)
+	}
+}
+
+func TestBSD(t *testing.T) {
+	program := "const msg = \"Hi\"\nfun main\nprint(msg)\nexit 2\nend\n"
+	for _, os := range []OS{FreeBSD, OpenBSD} {
+		config, err := NewTargetConfigForOS(64, os, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asmcode, err := compile(config, program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectInOrder(t, asmcode, []string{"_start:", "mov rax, 4", "mov rsi, msg", "syscall", "mov rax, 1", "mov rdi, 2", "syscall"})
+		if hasNote := strings.Contains(asmcode, "section .note.openbsd.ident"); hasNote != (os == OpenBSD) {
+			t.Errorf("%s: expected the OpenBSD note section only for OpenBSD:\n%s", os, asmcode)
+		}
+		if hasNote := strings.Contains(asmcode, "section .note.tag"); hasNote != (os == FreeBSD) {
+			t.Errorf("%s: expected the FreeBSD note section only for FreeBSD:\n%s", os, asmcode)
+		}
+		if os == FreeBSD {
+			expectInOrder(t, asmcode, []string{"section .note.tag note", "dd 8, 4, 1", "db \"FreeBSD\", 0", "dd 1200000"})
+		}
+
+		// The arguments are pushed to the stack on 32-bit BSD
+		if config, err = NewTargetConfigForOS(32, os, false); err != nil {
+			t.Fatal(err)
+		}
+		if asmcode, err = compile(config, program); err != nil {
+			t.Fatal(err)
+		}
+		expectInOrder(t, asmcode, []string{"push dword msg", "mov eax, 4", "int 0x80", "push dword 2", "mov eax, 1", "int 0x80"})
+	}
+	if _, err := NewTargetConfigForOS(16, FreeBSD, false); err == nil {
+		t.Error("expected an error for 16-bit FreeBSD")
+	}
+	if _, err := NewTargetConfigForOS(64, DOS, false); err == nil {
+		t.Error("expected an error for 64-bit DOS")
+	}
+}
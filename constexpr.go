@@ -0,0 +1,265 @@
+package battlestarlib
+
+import (
+	"math/big"
+	"strings"
+)
+
+// How tightly the binary operators in constant expressions bind, like in C
+var exprPrecedence = map[string]int{"|": 1, "^": 2, "&": 3, "<<": 4, ">>": 4, "+": 5, "-": 5, "*": 6, "/": 6, "%": 6}
+
+// The assembler has different operators for signed division and modulo
+var nasmOperators = map[string]string{"/": "//", "%": "%%"}
+
+// constExpr is the result of evaluating a constant expression.
+// If the expression refers to a name that only the assembler knows the value of,
+// like the length of a constant, it is kept as an expression for the assembler instead.
+type constExpr struct {
+	value    *big.Int
+	symbolic string
+}
+
+func (e constExpr) String() string {
+	if e.value == nil {
+		return e.symbolic
+	}
+	return e.value.String()
+}
+
+// exprParser evaluates the constant expression that starts at the given token in a statement
+type exprParser struct {
+	config      *TargetConfig
+	pst         *ParseState
+	st          Statement
+	i           int   // the current token
+	notConstant error // for the first name that can not be used in a constant expression, if any
+}
+
+// numericValue returns the value of a constant, if it is a single number
+func (n *ConstDecl) numericValue() (string, bool) {
+	if len(n.Values) != 1 || n.Values[0].T != VALUE {
+		return "", false
+	}
+	if _, _, err := parseLiteral(n.Values[0].Value); err != nil {
+		return "", false
+	}
+	return n.Values[0].Value, true
+}
+
+// fitsInBits checks if the given number can be stored in the given number of bits, either as a signed or as an unsigned number
+func fitsInBits(v *big.Int, bits int) bool {
+	if v.Sign() >= 0 {
+		return v.BitLen() <= bits
+	}
+	return new(big.Int).Not(v).BitLen() < bits
+}
+
+// startsExpr checks if a constant expression can start with the given token
+func startsExpr(tok Token) bool {
+	switch tok.T {
+	case VALUE, VALIDNAME:
+		return true
+	case EXPROP:
+		return tok.Value == "(" || tok.Value == "-" || tok.Value == "~"
+	}
+	return false
+}
+
+// binaryOperator returns the binary operator at the current token, if there is one
+func (p *exprParser) binaryOperator() (string, bool) {
+	if p.i >= len(p.st) {
+		return "", false
+	}
+	switch tok := p.st[p.i]; tok.T {
+	case EXPROP:
+		_, ok := exprPrecedence[tok.Value]
+		return tok.Value, ok
+	case SHL, SHR:
+		return tok.Value, true
+	}
+	return "", false
+}
+
+// expr evaluates an expression where all binary operators bind at least as tightly as the given precedence
+func (p *exprParser) expr(precedence int) (constExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return left, err
+	}
+	for {
+		op, ok := p.binaryOperator()
+		if !ok || exprPrecedence[op] < precedence {
+			return left, nil
+		}
+		opIndex := p.i
+		p.i++
+		right, err := p.expr(exprPrecedence[op] + 1)
+		if err != nil {
+			return right, err
+		}
+		if left, err = p.apply(opIndex, op, left, right); err != nil {
+			return left, err
+		}
+	}
+}
+
+// unary evaluates a value, a name, an expression in parentheses or a negated or inverted value
+func (p *exprParser) unary() (constExpr, error) {
+	if p.i >= len(p.st) {
+		return constExpr{}, p.st.errorf(len(p.st)-1, "expected a value at the end of the constant expression")
+	}
+	tok := p.st[p.i]
+	p.i++
+	switch {
+	case tok.T == EXPROP && tok.Value == "(":
+		e, err := p.expr(1)
+		if err != nil {
+			return e, err
+		}
+		if p.i >= len(p.st) {
+			return e, p.st.errorf(len(p.st)-1, "missing ) at the end of the constant expression")
+		}
+		if p.st[p.i].T != EXPROP || p.st[p.i].Value != ")" {
+			return e, p.st.errorf(p.i, "expected ) in the constant expression, not %s", p.st[p.i].Value)
+		}
+		p.i++
+		return e, nil
+	case tok.T == EXPROP && (tok.Value == "-" || tok.Value == "~"):
+		e, err := p.unary()
+		if err != nil {
+			return e, err
+		}
+		if e.value == nil {
+			return constExpr{symbolic: tok.Value + e.symbolic}, nil
+		}
+		if tok.Value == "-" {
+			e.value = new(big.Int).Neg(e.value)
+		} else {
+			e.value = new(big.Int).Not(e.value)
+		}
+		return e, p.check(p.i-1, e)
+	case tok.T == VALUE:
+		return literalExpr(tok.Value), nil
+	case tok.T == VALIDNAME:
+		if value, ok := p.pst.constants[tok.Value]; ok {
+			return literalExpr(value), nil
+		}
+		// A single name is not an error, only names that are used together with operators
+		if p.notConstant == nil {
+			if strings.HasPrefix(tok.Value, "[") {
+				p.notConstant = p.st.errorf(p.i-1, "the length of a variable is not known until the program runs, and can not be used in a constant expression")
+			} else if !strings.HasPrefix(tok.Value, "_length_of_") && !has(p.pst.definedNames, tok.Value) {
+				p.notConstant = p.st.errorf(p.i-1, "%s is undefined and can not be used in a constant expression", tok.Value)
+			}
+		}
+		return constExpr{symbolic: tok.Value}, nil
+	}
+	return constExpr{}, p.st.errorf(p.i-1, "expected a value in the constant expression, not %s", tok.Value)
+}
+
+// literalExpr returns a number as a constant expression, or the text of the literal if it is not a number, like "$"
+func literalExpr(s string) constExpr {
+	negative, magnitude, err := parseLiteral(s)
+	if err != nil {
+		return constExpr{symbolic: s}
+	}
+	v := new(big.Int).SetUint64(magnitude)
+	if negative {
+		v.Neg(v)
+	}
+	return constExpr{value: v}
+}
+
+// check reports an error if the value of the expression does not fit in a register
+func (p *exprParser) check(i int, e constExpr) error {
+	if e.value != nil && !fitsInBits(e.value, p.config.PlatformBits) {
+		return p.st.errorf(i, "constant expression overflows %d bits: %s", p.config.PlatformBits, e.value)
+	}
+	return nil
+}
+
+// apply evaluates a binary operation, where i is the index of the operator token
+func (p *exprParser) apply(i int, op string, left, right constExpr) (constExpr, error) {
+	if right.value != nil && right.value.Sign() == 0 && (op == "/" || op == "%") {
+		return constExpr{}, p.st.errorf(i, "division by zero in constant expression")
+	}
+	if (op == "<<" || op == ">>") && right.value != nil && (right.value.Sign() < 0 || right.value.Cmp(big.NewInt(int64(p.config.PlatformBits))) >= 0) {
+		return constExpr{}, p.st.errorf(i, "can not shift by %s on a %d-bit platform", right.value, p.config.PlatformBits)
+	}
+	if left.value == nil || right.value == nil {
+		nasmOp := op
+		if s, ok := nasmOperators[op]; ok {
+			nasmOp = s
+		}
+		return constExpr{symbolic: "(" + left.String() + nasmOp + right.String() + ")"}, nil
+	}
+	v := new(big.Int)
+	switch op {
+	case "+":
+		v.Add(left.value, right.value)
+	case "-":
+		v.Sub(left.value, right.value)
+	case "*":
+		v.Mul(left.value, right.value)
+	case "/":
+		v.Quo(left.value, right.value)
+	case "%":
+		v.Rem(left.value, right.value)
+	case "&":
+		v.And(left.value, right.value)
+	case "|":
+		v.Or(left.value, right.value)
+	case "^":
+		v.Xor(left.value, right.value)
+	case "<<":
+		v.Lsh(left.value, uint(right.value.Uint64()))
+	case ">>":
+		v.Rsh(left.value, uint(right.value.Uint64()))
+	}
+	e := constExpr{value: v}
+	return e, p.check(i, e)
+}
+
+// foldConstants replaces each constant expression in the statement, like "4 * 1024" or "len(msg) * 2", with a single value.
+// Names of numeric constants stand for their values, both on their own and in constant expressions,
+// except where they are assigned to, declared or printed.
+func (config *TargetConfig) foldConstants(st Statement, pst *ParseState) (Statement, error) {
+	for i := 0; i < len(st); i++ {
+		if !startsExpr(st[i]) {
+			continue
+		}
+		p := &exprParser{config: config, pst: pst, st: st, i: i}
+		e, err := p.expr(1)
+		if err != nil {
+			return nil, err
+		}
+		if p.i-i == 1 {
+			// A single value or name is only replaced if it is the name of a numeric constant
+			if _, ok := pst.constants[st[i].Value]; !ok || (st[i].T != VALIDNAME) || namePosition(st, i) {
+				continue
+			}
+		} else if p.notConstant != nil {
+			return nil, p.notConstant
+		}
+		last := st[p.i-1].Position
+		pos := st[i].Position
+		pos.Length = last.Offset + last.Length - pos.Offset
+		folded := Token{VALUE, e.String(), pos, ""}
+		config.logf(LogReductions, "Evaluated constant expression to %v", folded)
+		st = append(append(st[:i:i], folded), st[p.i:]...)
+	}
+	return st, nil
+}
+
+// namePosition checks if token number i in the statement must be a name, and not a value.
+// This is the first token, which may be assigned to, the name in a declaration, like "const X = 5",
+// and the constant given to print.
+func namePosition(st Statement, i int) bool {
+	if i == 0 {
+		return true
+	}
+	if (i == 1) && (st[0].T == KEYWORD) && has([]string{"const", "var", "local", "fun", "extern"}, st[0].Value) {
+		return true
+	}
+	return (st[i-1].T == BUILTIN) && (st[i-1].Value == "print")
+}
@@ -0,0 +1,38 @@
+package battlestarlib
+
+import (
+	"testing"
+)
+
+func TestConstantExpressions(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "const BUF = 4 * 1024\nconst msg = \"Hi\"\nvar line BUF + 1\nvar twice len(msg) * 2\nfun main\nrax = (BUF + 1) << 2\nrbx = len(msg) * 2 + 1\nrcx = ~0 & 0xff\nloop BUF\nend\nrax == BUF - 2 * 3 % 4\nend\nrdx = BUF\nrdx = BUF + 0\nexit\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{
+		"BUF:\tdq 4096",
+		"mov rax, 16388", "mov rbx, ((_length_of_msg*2)+1)", "mov rcx, 255",
+		"mov rcx, 4096",
+		"cmp rax, 4094",
+		// A numeric constant is its value, both on its own and in an expression
+		"mov rdx, 4096", "mov rdx, 4096",
+		"line: resb 4097", "twice: resb (_length_of_msg*2)",
+	})
+
+	config, err = NewTargetConfig(16, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compile(config, "fun main\nax = 255 * 256\nax = -32768 + 1\nend\n"); err != nil {
+		t.Errorf("expected the expressions to fit in 16 bits, got: %v", err)
+	}
+	for _, program := range []string{"const X = 256 * 256\n", "const X = 1 << 16\n", "const X = 4 / (2 - 2)\n", "const X = (1 + 2\n", "const X = Y * 2\n", "var buf 1\nfun main\nax = len(buf) + 1\nend\n"} {
+		if _, err := compile(config, program); err == nil {
+			t.Errorf("expected an error when compiling:\n%s", program)
+		}
+	}
+}
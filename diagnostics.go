@@ -0,0 +1,141 @@
+package battlestarlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is how serious a diagnostic message is
+type Severity int
+
+// These are the possible severities for a diagnostic message
+const (
+	NOTE    Severity = iota // information about the generated code
+	WARNING                 // the code compiles, but may not do what was intended
+	ERROR                   // the statement could not be compiled
+)
+
+type (
+	// Diagnostic is an error, warning or note that was found when compiling
+	Diagnostic struct {
+		Severity  Severity
+		Position         // where in the source code the message is about
+		Token     string // the token the message is about, if any
+		Statement string // the statement the message is about, if any
+		Message   string
+	}
+
+	// Diagnostics is a list of diagnostic messages, in the order they were found.
+	// When returned as an error, it contains at least one message with the ERROR severity.
+	Diagnostics []Diagnostic
+)
+
+// String returns "note", "warning" or "error"
+func (s Severity) String() string {
+	switch s {
+	case NOTE:
+		return "note"
+	case WARNING:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// String returns the diagnostic message, with severity and position
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%s: %s: %s", d.Position, d.Severity, d.Message)
+	if d.Token != "" {
+		s += fmt.Sprintf(" (at %q)", d.Token)
+	}
+	return s
+}
+
+// Errors returns only the diagnostics with the ERROR severity
+func (diags Diagnostics) Errors() Diagnostics {
+	var errs Diagnostics
+	for _, d := range diags {
+		if d.Severity == ERROR {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// Error returns all error messages, one per line
+func (diags Diagnostics) Error() string {
+	var lines []string
+	for _, d := range diags.Errors() {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Err returns the diagnostics as an error if there are any errors, or nil
+func (diags Diagnostics) Err() error {
+	if len(diags.Errors()) == 0 {
+		return nil
+	}
+	return diags
+}
+
+// statementString returns the values of the tokens in a statement, separated by spaces
+func statementString(st Statement) string {
+	words := make([]string, len(st))
+	for i, tok := range st {
+		words[i] = tok.Value
+	}
+	return strings.Join(words, " ")
+}
+
+// report adds a diagnostic message about token number i in the given statement
+func (ps *ProgramState) report(severity Severity, st Statement, i int, format string, v ...interface{}) {
+	d := Diagnostic{Severity: severity, Statement: statementString(st), Message: fmt.Sprintf(format, v...)}
+	if i >= 0 && i < len(st) {
+		d.Position = st[i].Position
+		d.Token = st[i].Value
+	} else if len(st) > 0 {
+		d.Position = st[0].Position
+	}
+	ps.diagnostics = append(ps.diagnostics, d)
+}
+
+// warn adds a warning about token number i in the given statement
+func (ps *ProgramState) warn(st Statement, i int, format string, v ...interface{}) {
+	ps.report(WARNING, st, i, format, v...)
+}
+
+// note adds a note about token number i in the given statement
+func (ps *ProgramState) note(st Statement, i int, format string, v ...interface{}) {
+	ps.report(NOTE, st, i, format, v...)
+}
+
+// withError returns the diagnostics with the given error added, as a message about the given statement.
+// If the error is a list of diagnostics, they are all added.
+func (diags Diagnostics) withError(err error, statement string) Diagnostics {
+	switch e := err.(type) {
+	case Diagnostics:
+		return append(diags, e...)
+	case *CompileError:
+		return append(diags, Diagnostic{Severity: ERROR, Position: e.Position, Token: e.Token, Statement: statement, Message: e.Message})
+	}
+	return append(diags, Diagnostic{Severity: ERROR, Statement: statement, Message: err.Error()})
+}
+
+// addError adds the given error as a diagnostic message for the given statement
+func (ps *ProgramState) addError(st Statement, err error) {
+	d := Diagnostic{Severity: ERROR, Statement: statementString(st), Message: err.Error()}
+	if compileError, ok := err.(*CompileError); ok {
+		d.Position = compileError.Position
+		d.Token = compileError.Token
+		d.Message = compileError.Message
+	} else if len(st) > 0 {
+		d.Position = st[0].Position
+	}
+	ps.diagnostics = append(ps.diagnostics, d)
+}
+
+// Diagnostics returns all errors, warnings and notes that have been found so far
+func (ps *ProgramState) Diagnostics() Diagnostics {
+	return ps.diagnostics
+}
@@ -0,0 +1,39 @@
+package battlestarlib
+
+import (
+	"fmt"
+)
+
+// CompileError is returned when a Battlestar program can not be compiled.
+// It points at the token that caused the problem.
+type CompileError struct {
+	Position        // where in the source code the error was found
+	Token    string // the offending token, if any
+	Message  string // description of the problem
+}
+
+// Error returns the error message, together with the position and the offending token
+func (e *CompileError) Error() string {
+	s := fmt.Sprintf("%s: %s", e.Position, e.Message)
+	if e.Token != "" {
+		s += fmt.Sprintf(" (at %q)", e.Token)
+	}
+	return s
+}
+
+// newCompileError returns a new CompileError that points at the given token
+func newCompileError(tok Token, format string, v ...interface{}) *CompileError {
+	return &CompileError{tok.Position, tok.Value, fmt.Sprintf(format, v...)}
+}
+
+// errorf returns a CompileError that points at token number i in the statement.
+// If there is no such token, the first token is used, if any.
+func (st Statement) errorf(i int, format string, v ...interface{}) error {
+	if i < 0 || i >= len(st) {
+		i = 0
+	}
+	if len(st) == 0 {
+		return &CompileError{Message: fmt.Sprintf(format, v...)}
+	}
+	return newCompileError(st[i], format, v...)
+}
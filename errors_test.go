@@ -0,0 +1,72 @@
+package battlestarlib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileError(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := config.Tokenize("fun main\nfoo bar baz\nrax = 1\nbar baz\nend\n", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := NewProgramState()
+	_, asmcode, err := config.CompileTokens(tokens, ps)
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("Expected Diagnostics, got: %v\n", err)
+	}
+	// Both bad statements should be reported, and the good one in between should be compiled
+	if len(diags) != 2 || diags[0].Line != 2 || diags[0].Token != "foo" || diags[1].Line != 4 {
+		t.Errorf("Wrong diagnostics: %v\n", diags)
+	}
+	if !strings.Contains(asmcode, "mov rax, 1") {
+		t.Errorf("Statements after an error should still be compiled:\n%s\n", asmcode)
+	}
+}
+
+func TestCompileErrorTokenize(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := config.TokenizeFile("main.bts", "fun main\n  rax = a:b\nrbx = 2\nrcx = (c:d)\nend\n", " ")
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("Expected Diagnostics, got: %v\n", err)
+	}
+	// Both bad lines should be reported, also when the bad token is found when splitting a word
+	if len(diags) != 2 || diags[1].Token != "c:d" || diags[1].Line != 4 {
+		t.Errorf("Wrong diagnostics: %v\n", diags)
+	}
+	if diags[0].Token != "a:b" || diags[0].Position.String() != "main.bts:2:9" || diags[0].Offset != 17 {
+		t.Errorf("Wrong position for the compile error: %v\n", diags[0])
+	}
+	// The other lines are still tokenized, so that compilation can continue
+	_, asmcode, err := config.CompileTokens(tokens, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(asmcode, "mov rbx, 2") {
+		t.Errorf("Lines after an error should still be tokenized:\n%s\n", asmcode)
+	}
+}
+
+func TestIncompleteStatements(t *testing.T) {
+	for _, bits := range []int{16, 32, 64} {
+		config, err := NewTargetConfig(bits, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Statements that end too early must give an error, not a panic
+		for _, program := range []string{"fun main\nprint\nend\n", "fun main\nsyscall\nend\n", "fun main\nint 0x80\nend\n"} {
+			if _, err := compile(config, program); err == nil {
+				t.Errorf("%d bits: expected an error when compiling:\n%s", bits, program)
+			}
+		}
+	}
+}
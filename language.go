@@ -1,19 +1,26 @@
 package battlestarlib
 
 import (
-	"log"
 	"strconv"
 	"strings"
 )
 
 var (
-	// TODO: Several of the operators (and the difference between signed/unsigned)
-	operators = []string{"=", "+=", "-=", "*=", "/=", "&=", "|=", "^=", "->", "<<<", ">>>", "<<", ">>", "<->", "==>", "<=="}
+	// Numbers are unsigned when dividing and shifting, unless the signed variants "/s=", "%s=" and ">>s" are used.
+	// "/u=" and "%u=" are the same as "/=" and "%=", but makes it clear that the numbers are unsigned.
+	operators = []string{"=", "+=", "-=", "*=", "/=", "/u=", "/s=", "%=", "%u=", "%s=", "&=", "|=", "^=", "->", "<<<", ">>>", "<<", ">>", ">>s", "<->", "==>", "<=="}
 
-	comparisons = []string{"==", "!=", "<", ">", "<=", ">="}
+	// Numbers are signed when comparing, unless the unsigned variants ending with "u" are used
+	comparisons = []string{"==", "!=", "<", ">", "<=", ">=", "<u", ">u", "<=u", ">=u"}
+
+	// && binds tighter than ||
+	logicalOperators = []string{"&&", "||"}
+
+	// Operators in constant expressions, like "4 * 1024". "<<" and ">>" can also be used, and parentheses.
+	expressionOperators = []string{"+", "-", "*", "/", "%", "&", "|", "^", "~"}
 
 	// TODO: "use" and make the bootable kernel work somehow
-	keywords = []string{"fun", "ret", "const", "call", "extern", "end", "bootable", "counter", "address", "value", "loopwrite", "rawloop", "loop", "break", "continue", "use", "asm", "mem", "readbyte", "readword", "readdouble", "membyte", "memword", "memdouble", "var", "write", "noret"}
+	keywords = []string{"fun", "ret", "const", "call", "extern", "end", "elif", "else", "while", "bootable", "counter", "address", "value", "loopwrite", "rawloop", "loop", "break", "continue", "use", "asm", "mem", "readbyte", "readword", "readdouble", "membyte", "memword", "memdouble", "var", "local", "write", "noret"}
 
 	// TODO: "read"
 	builtins = []string{"len", "int", "exit", "halt", "chr", "print", "read", "syscall"} // built-in functions
@@ -58,60 +65,53 @@ func validName(s string) bool {
 	return true
 }
 
-// Remove one line commants, both // and # are ok
+// Remove one line commants, both // and # are ok.
+// Comment characters inside string and character literals are kept.
 func removecomments(s string) string {
 	if strings.HasPrefix(s, "//") || strings.HasPrefix(s, "#") {
 		return ""
-	} else if pos := strings.Index(s, "//"); pos != -1 {
-		// Strip away everything after the first // on the line
-		return s[:pos]
-	} else if pos := strings.Index(s, "#"); pos != -1 {
-		// Strip away everything after the first # on the line
-		return s[:pos]
 	}
-	return s
-}
-
-// Replace \n, \t, \r and \0 with the appropriate values
-func stringReplacements(s string) string {
-	rtable := map[string]int{"\\t": 9, "\\n": 10, "\\r": 13, "\\0": 0}
-	for key, value := range rtable {
-		if strings.Contains(s, key) {
-			if strings.Contains(s, key+"\"") {
-				s = strings.Replace(s, key+"\"", "\", "+strconv.Itoa(value), -1)
-			} else {
-				s = strings.Replace(s, key, "\", "+strconv.Itoa(value)+", \"", -1)
-			}
-		}
+	pos := indexOutsideQuotes(s, "//")
+	if hashpos := indexOutsideQuotes(s, "#"); hashpos != -1 && (pos == -1 || hashpos < pos) {
+		pos = hashpos
+	}
+	if pos != -1 {
+		// Strip away everything after the first // or # on the line
+		return s[:pos]
 	}
 	return s
 }
 
+// Checks if the given string is a numeric literal, like 42, -7, 0x2a, 0b101010, 0o52 or 1_000
 func isValue(s string) bool {
-	_, err := strconv.Atoi(s)
+	_, err := parseNumber(s)
 	return err == nil
 }
 
-func (config *TargetConfig) reservedAndValue(st Statement) string {
+func (config *TargetConfig) reservedAndValue(st Statement) (string, error) {
+	if len(st) < 2 {
+		return "", st.errorf(0, "unable to handle reserved word without a value")
+	}
 	if st[0].Value == "funparam" {
 		paramoffset, err := strconv.Atoi(st[1].Value)
 		if err != nil {
-			log.Fatalln("Error: Invalid offset for", st[0].Value+":", st[1].Value)
+			return "", st.errorf(1, "invalid offset for %s: %s", st[0].Value, st[1].Value)
+		}
+		reg, err := config.paramnum2reg(paramoffset)
+		if err != nil {
+			return "", st.errorf(0, "%s", err)
 		}
-		return config.paramnum2reg(paramoffset)
+		return reg, nil
 	} else if st[0].Value == "sysparam" {
 		paramoffset, err := strconv.Atoi(st[1].Value)
 		if err != nil {
-			log.Fatalln("Error: Invalid offset for", st[0].Value+":", st[1].Value)
+			return "", st.errorf(1, "invalid offset for %s: %s", st[0].Value, st[1].Value)
 		}
 		if paramoffset >= len(config.interruptParameterRegisters) {
-			log.Fatalln("Error: Invalid offset for", st[0].Value+":", st[1].Value, "(too high)")
+			return "", st.errorf(1, "invalid offset for %s: %s (too high)", st[0].Value, st[1].Value)
 		}
-		return config.interruptParameterRegisters[paramoffset]
-	} else {
-		// TODO: Implement support for other lists
-		log.Fatalln("Error: Can only handle \"funparam\" and \"sysparam\" reserved words.")
+		return config.interruptParameterRegisters[paramoffset], nil
 	}
-	log.Fatalln("Error: Unable to handle reserved word and value:", st[0].Value, st[1].Value)
-	return ""
+	// TODO: Implement support for other lists
+	return "", st.errorf(0, "can only handle \"funparam\" and \"sysparam\" reserved words")
 }
@@ -0,0 +1,281 @@
+package battlestarlib
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// quoteEnd returns the index of the quote that ends the string or character literal
+// that starts at s[start], or -1 if the literal is not terminated.
+func quoteEnd(s string, start int) int {
+	quote := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			// Skip the escaped character
+			i++
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// indexOutsideQuotes returns the index of the first occurrence of sep in s that is
+// not inside a string or character literal, or -1 if there is none.
+func indexOutsideQuotes(s, sep string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\'' {
+			end := quoteEnd(s, i)
+			if end == -1 {
+				return -1
+			}
+			i = end
+			continue
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitOutsideQuotes splits s at every sep that is not inside a string or character literal
+func splitOutsideQuotes(s, sep string) []string {
+	var parts []string
+	for {
+		pos := indexOutsideQuotes(s, sep)
+		if pos == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:pos])
+		s = s[pos+len(sep):]
+	}
+}
+
+// unescape reads the escape sequence that starts at s[0] (after the backslash) and
+// returns the bytes it represents and the number of bytes of s that were used.
+func unescape(s string) ([]byte, int, error) {
+	if len(s) == 0 {
+		return nil, 0, fmt.Errorf("unterminated escape sequence")
+	}
+	switch s[0] {
+	case 'n':
+		return []byte{10}, 1, nil
+	case 't':
+		return []byte{9}, 1, nil
+	case 'r':
+		return []byte{13}, 1, nil
+	case '0':
+		return []byte{0}, 1, nil
+	case 'a':
+		return []byte{7}, 1, nil
+	case 'b':
+		return []byte{8}, 1, nil
+	case 'e':
+		return []byte{27}, 1, nil
+	case '\\', '"', '\'':
+		return []byte{s[0]}, 1, nil
+	case 'x':
+		if len(s) < 3 {
+			return nil, 0, fmt.Errorf("\\x must be followed by two hexadecimal digits")
+		}
+		n, err := strconv.ParseUint(s[1:3], 16, 8)
+		if err != nil {
+			return nil, 0, fmt.Errorf("\\x must be followed by two hexadecimal digits, not %q", s[1:3])
+		}
+		return []byte{byte(n)}, 3, nil
+	case 'u':
+		end := strings.Index(s, "}")
+		if !strings.HasPrefix(s, "u{") || end == -1 {
+			return nil, 0, fmt.Errorf("\\u must be followed by a hexadecimal code point in braces, like \\u{263A}")
+		}
+		n, err := strconv.ParseUint(s[2:end], 16, 32)
+		if err != nil || n > utf8.MaxRune {
+			return nil, 0, fmt.Errorf("invalid unicode code point: %q", s[2:end])
+		}
+		buf := make([]byte, utf8.UTFMax)
+		return buf[:utf8.EncodeRune(buf, rune(n))], end + 1, nil
+	}
+	return nil, 0, fmt.Errorf("unknown escape sequence: \\%c", s[0])
+}
+
+// unquote returns the bytes of the string or character literal that starts at s[0],
+// and the number of bytes of s that the literal, including quotes, takes up.
+func unquote(s string) ([]byte, int, error) {
+	end := quoteEnd(s, 0)
+	if end == -1 {
+		return nil, 0, fmt.Errorf("unterminated literal: %s", s)
+	}
+	var data []byte
+	for i := 1; i < end; i++ {
+		if s[i] != '\\' {
+			data = append(data, s[i])
+			continue
+		}
+		b, n, err := unescape(s[i+1 : end])
+		if err != nil {
+			return nil, 0, err
+		}
+		data = append(data, b...)
+		i += n
+	}
+	return data, end + 1, nil
+}
+
+// charValue returns the numeric value of a character literal, like 'A' or '\n'
+func charValue(s string) (int, error) {
+	data, n, err := unquote(s)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(s) {
+		return 0, fmt.Errorf("unexpected characters after character literal: %s", s[n:])
+	}
+	if len(data) == 0 {
+		return 0, fmt.Errorf("empty character literal")
+	}
+	if r, size := utf8.DecodeRune(data); size == len(data) {
+		return int(r), nil
+	}
+	return 0, fmt.Errorf("more than one character in character literal: %s", s)
+}
+
+// dbString returns the given bytes as arguments for the NASM db pseudo-instruction.
+// Printable characters are placed in quotes, and all other bytes are given as numbers.
+func dbString(data []byte) string {
+	var (
+		items  []string
+		quoted []byte
+	)
+	for _, b := range data {
+		if b >= ' ' && b <= '~' && b != '"' {
+			quoted = append(quoted, b)
+			continue
+		}
+		if len(quoted) > 0 {
+			items = append(items, "\""+string(quoted)+"\"")
+			quoted = nil
+		}
+		items = append(items, strconv.Itoa(int(b)))
+	}
+	if len(quoted) > 0 || len(items) == 0 {
+		items = append(items, "\""+string(quoted)+"\"")
+	}
+	return strings.Join(items, ", ")
+}
+
+// dataList converts a comma separated list of string literals, character literals and
+// other values (like numbers or names) to arguments for the NASM db pseudo-instruction.
+// Closing parenthesis at the end of the list are ignored, since they belong to a surrounding call.
+func dataList(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), ")")
+	var items []string
+	for _, item := range splitOutsideQuotes(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return "", fmt.Errorf("missing value in list: %s", s)
+		}
+		switch item[0] {
+		case '"':
+			data, n, err := unquote(item)
+			if err != nil {
+				return "", err
+			}
+			if n != len(item) {
+				return "", fmt.Errorf("unexpected characters after string: %s", item[n:])
+			}
+			items = append(items, dbString(data))
+		case '\'':
+			value, err := charValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, strconv.Itoa(value))
+		default:
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ", "), nil
+}
+
+// errNumberTooLarge is returned when a numeric literal does not fit in 64 bits
+var errNumberTooLarge = errors.New("number is too large")
+
+// parseLiteral parses a numeric literal, like 42, -7, 0x2A, 0b101010, 0o52, 1_000 or 'A'.
+// The sign and the magnitude are returned separately, so that the full unsigned 64-bit range can be used.
+func parseLiteral(s string) (negative bool, magnitude uint64, err error) {
+	if strings.HasPrefix(s, "'") {
+		value, err := charValue(s)
+		return false, uint64(value), err
+	}
+	digits := s
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		negative = digits[0] == '-'
+		digits = digits[1:]
+	}
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+		if base != 10 {
+			digits = digits[2:]
+		}
+	}
+	// Underscores may be used for separating digits, but not at the start or end
+	if strings.HasPrefix(digits, "_") || strings.HasSuffix(digits, "_") || strings.Contains(digits, "__") {
+		return false, 0, fmt.Errorf("misplaced _ in number: %s", s)
+	}
+	digits = strings.Replace(digits, "_", "", -1)
+	magnitude, err = strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return false, 0, errNumberTooLarge
+		}
+		return false, 0, fmt.Errorf("not a number: %s", s)
+	}
+	if negative && magnitude > 1<<63 {
+		return false, 0, errNumberTooLarge
+	}
+	return negative, magnitude, nil
+}
+
+// parseNumber parses a numeric literal. Values above the signed 64-bit range wrap around,
+// the same way the assembler treats them.
+func parseNumber(s string) (int64, error) {
+	negative, magnitude, err := parseLiteral(s)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		return -int64(magnitude), nil
+	}
+	return int64(magnitude), nil
+}
+
+// Number returns the integer value of a VALUE token
+func (tok Token) Number() (int64, error) {
+	if tok.T != VALUE {
+		return 0, fmt.Errorf("not a value: %s", tok.Value)
+	}
+	return parseNumber(tok.Value)
+}
+
+// powerOfTwo returns the exponent if the token is a value that is a power of two, larger than 1
+func (tok Token) powerOfTwo() (int, bool) {
+	n, err := tok.Number()
+	if err != nil || n < 2 || n&(n-1) != 0 {
+		return 0, false
+	}
+	return bits.TrailingZeros64(uint64(n)), true
+}
@@ -0,0 +1,109 @@
+package battlestarlib
+
+import (
+	"testing"
+)
+
+func TestDataList(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{`"Hello, World!\n"`, `"Hello, World!", 10`},
+		{`"Hi there", 10`, `"Hi there", 10`},
+		{`"  two  spaces  "`, `"  two  spaces  "`},
+		{`"say \"hi\""`, `"say ", 34, "hi", 34`},
+		{`"a\\b\tc\0"`, `"a\b", 9, "c", 0`},
+		{`"\x41\x42"`, `"AB"`},
+		{`"\u{263A}"`, `226, 152, 186`},
+		{`'A', 'B', 'C'`, `65, 66, 67`},
+		{`""`, `""`},
+		{`"x")`, `"x"`},
+	}
+	for _, test := range tests {
+		out, err := dataList(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+		} else if out != test.out {
+			t.Errorf("%s: expected %s, got %s", test.in, test.out, out)
+		}
+	}
+	for _, in := range []string{`"unterminated`, `"bad \q escape"`, `"\xZZ"`, `"\u{110000}"`, `"a" "b"`, `'ab'`} {
+		if _, err := dataList(in); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+}
+
+func TestStringTokens(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := config.Tokenize("const msg = \"a # b // c\", '#' # comment\nrax = ' '", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Token{
+		{T: KEYWORD, Value: "const"},
+		{T: VALIDNAME, Value: "msg"},
+		{T: ASSIGNMENT, Value: "="},
+		{T: STRING, Value: `"a # b // c", 35`},
+		{T: SEP, Value: ";"},
+		{T: REGISTER, Value: "rax"},
+		{T: ASSIGNMENT, Value: "="},
+		{T: VALUE, Value: "32"},
+		{T: SEP, Value: ";"},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, tok := range tokens {
+		if tok.T != expected[i].T || tok.Value != expected[i].Value {
+			t.Errorf("token %d: expected %s %s, got %s %s", i, expected[i].T, expected[i].Value, tok.T, tok.Value)
+		}
+	}
+	if tokens[3].Col != 13 || tokens[3].Length != 18 {
+		t.Errorf("expected the string to start at column 13 with length 18, got %s with length %d", tokens[3].Position, tokens[3].Length)
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int64
+		bits int
+	}{
+		{"42", 42, 6},
+		{"-7", -7, 4},
+		{"-128", -128, 8},
+		{"0x2A", 42, 6},
+		{"0b1010_1010", 170, 8},
+		{"0o52", 42, 6},
+		{"1_000", 1000, 10},
+		{"'A'", 65, 7},
+		{"255", 255, 8},
+		{"256", 256, 9},
+		{"0xFFFFFFFFFFFFFFFF", -1, 64},
+	}
+	for _, test := range tests {
+		n, err := parseNumber(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if n != test.n {
+			t.Errorf("%s: expected %d, got %d", test.in, test.n, n)
+		}
+		if bits := numbits(test.in); bits != test.bits {
+			t.Errorf("%s: expected %d bits, got %d", test.in, test.bits, bits)
+		}
+	}
+	for _, in := range []string{"", "-", "0x", "_1", "1_", "1__0", "0b102", "12ab", "--5", "rax"} {
+		if _, err := parseNumber(in); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+	if _, err := parseNumber("0x1_0000_0000_0000_0000"); err != errNumberTooLarge {
+		t.Errorf("expected the number to be too large, got %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package battlestarlib
+
+// Logger is used for outputting debug information while compiling.
+// *log.Logger from the standard library satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// LogLevel is a set of flags that selects which debug information to log
+type LogLevel int
+
+// These are the different kinds of debug information that can be logged
+const (
+	LogTokens     LogLevel = 1 << iota // tokens, as they are found by the tokenizer
+	LogReductions                      // built-in function calls that are replaced with more basic code
+	LogCodegen                         // notes from the code generator
+	LogAll        = LogTokens | LogReductions | LogCodegen
+)
+
+// logf outputs debug information to the configured Logger, if the given level is enabled
+func (config *TargetConfig) logf(level LogLevel, format string, v ...interface{}) {
+	if config.Logger != nil && (config.LogLevel&level) != 0 {
+		config.Logger.Printf(format, v...)
+	}
+}
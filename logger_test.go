@@ -0,0 +1,38 @@
+package battlestarlib
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	config.Logger = log.New(&buf, "", 0)
+	// Silent by default, even with a logger
+	if _, err := config.Tokenize("rax = 42", " "); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output when no log level is set, got:\n%s\n", buf.String())
+	}
+	config.LogLevel = LogTokens
+	if _, err := config.Tokenize("rax = 42", " "); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "TOKEN register:rax") {
+		t.Errorf("Expected the tokens to be logged, got:\n%s\n", buf.String())
+	}
+	// Finding inline C is also logged
+	if c := config.ExtractInlineC("inline_c\nint x = 1;\nend\n"); c != "int x = 1;\n" {
+		t.Errorf("Expected the inline C code, got: %q\n", c)
+	}
+	if !strings.Contains(buf.String(), "Found inline_c, starting inline_c block") {
+		t.Errorf("Expected the inline C block to be logged, got:\n%s\n", buf.String())
+	}
+}
@@ -0,0 +1,54 @@
+package battlestarlib
+
+// OS is an operating system that programs can be compiled for
+type OS int
+
+// The supported operating systems. DOS is only for 16-bit programs, and
+// Multiboot is for kernels that are booted by a multiboot bootloader, like GRUB, without an operating system.
+const (
+	Linux OS = iota
+	MacOS
+	Windows
+	FreeBSD
+	OpenBSD
+	DOS
+	Multiboot
+)
+
+var osNames = map[OS]string{Linux: "linux", MacOS: "darwin", Windows: "windows", FreeBSD: "freebsd", OpenBSD: "openbsd", DOS: "dos", Multiboot: "multiboot"}
+
+func (os OS) String() string {
+	if name, ok := osNames[os]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// isBSD returns true for the operating systems that use the BSD system calls, where
+// the arguments are pushed to the stack before calling int 0x80 on 32-bit platforms
+func (os OS) isBSD() bool {
+	return (os == MacOS) || (os == FreeBSD) || (os == OpenBSD)
+}
+
+// openBSDNote is the ELF note section that OpenBSD requires before it will run an executable
+const openBSDNote = `
+section .note.openbsd.ident note
+	align 2
+	dd 8, 4, 1			; name size, description size and type
+	db "OpenBSD", 0
+	dd 0
+	align 2
+`
+
+// freeBSDNote is the ELF note section that brands an executable as a FreeBSD executable, like crt1.o does.
+// Without it, FreeBSD only runs the executable if the fallback brand of the kernel happens to be FreeBSD.
+// The description is the oldest __FreeBSD_version the executable is made for, here FreeBSD 12.0.
+// ref: lib/csu/common/crtbrand.S in the FreeBSD source code
+const freeBSDNote = `
+section .note.tag note
+	align 4
+	dd 8, 4, 1			; name size, description size and type (NT_FREEBSD_ABI_TAG)
+	db "FreeBSD", 0
+	dd 1200000
+	align 4
+`
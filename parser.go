@@ -0,0 +1,446 @@
+package battlestarlib
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseState keeps track of the current state when parsing
+type ParseState struct {
+	definedNames []string          // all declared constants, variables, functions and external symbols
+	variables    map[string]int    // map of variable names and reserved bytes
+	constants    map[string]string // map of numeric constant names and values, for constant expressions
+	frame        *frame            // the local variables of the current function
+}
+
+// NewParseState returns a new state struct that is used when a program is parsed
+func NewParseState() *ParseState {
+	return &ParseState{definedNames: make([]string, 0), variables: make(map[string]int), constants: make(map[string]string), frame: newFrame()}
+}
+
+// declare keeps track of the names that are declared by the given statement,
+// since the built-in len() function and constant expressions need to know about them
+func (pst *ParseState) declare(n Node) {
+	switch n := n.(type) {
+	case *ConstDecl:
+		pst.definedNames = append(pst.definedNames, n.Name)
+		if value, ok := n.numericValue(); ok {
+			pst.constants[n.Name] = value
+		}
+	case *VarDecl:
+		pst.definedNames = append(pst.definedNames, n.Name)
+		if size, err := n.Size.Number(); err == nil {
+			pst.variables[n.Name] = int(size)
+		}
+	case *Local:
+		if size, err := n.Size.Number(); err == nil {
+			pst.frame.declare(n.Name, int(size))
+		}
+	case *FunDecl:
+		pst.definedNames = append(pst.definedNames, n.Name)
+		pst.frame = newFrame()
+		pst.frame.bind(n.Params)
+	case *Extern:
+		pst.definedNames = append(pst.definedNames, n.Name)
+	}
+}
+
+// Parse parses the given tokens into a list of statements.
+// Statements that can not be parsed are skipped, so that as many problems as possible can be found.
+// If any errors were found, they are returned as Diagnostics.
+func (config *TargetConfig) Parse(tokens []Token) ([]Node, error) {
+	if err := config.check(); err != nil {
+		return nil, err
+	}
+	var (
+		nodes []Node
+		pst   = NewParseState()
+		ps    = NewProgramState()
+	)
+	for _, st := range splitStatements(tokens) {
+		n, err := config.parseStatement(st, pst)
+		if err != nil {
+			ps.addError(st, err)
+			continue
+		}
+		pst.declare(n)
+		nodes = append(nodes, n)
+	}
+	return nodes, ps.diagnostics.Err()
+}
+
+// splitStatements splits a list of tokens into statements, at every SEP token
+func splitStatements(tokens []Token) []Statement {
+	var (
+		statements []Statement
+		statement  Statement
+	)
+	for _, token := range tokens {
+		if token.T == SEP {
+			if len(statement) > 0 {
+				statements = append(statements, statement)
+			}
+			statement = Statement{}
+		} else {
+			statement = append(statement, token)
+		}
+	}
+	if len(statement) > 0 {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+// condition parses the comparisons from token number i to the end of the statement,
+// like "rax == 3" or "rax > 0 && rbx < 4 || rcx == 0"
+func (st Statement) condition(i int) (Condition, error) {
+	var (
+		cond  Condition
+		group []Comparison
+	)
+	for {
+		if (i+3 > len(st)) || (st[i+1].T != COMPARISON) {
+			if i >= len(st) {
+				i = len(st) - 1
+			}
+			return cond, st.errorf(i, "expected a comparison, like: rax == 3")
+		}
+		group = append(group, Comparison{st[i], st[i+1].Value, st[i+2]})
+		i += 3
+		if i == len(st) {
+			break
+		}
+		if st[i].T != LOGICAL {
+			return cond, st.errorf(i, "expected && or || between comparisons")
+		}
+		if st[i].Value == "||" {
+			cond.Any = append(cond.Any, group)
+			group = nil
+		}
+		i++
+	}
+	cond.Any = append(cond.Any, group)
+	return cond, nil
+}
+
+// parseStatement reduces built-in function calls in the statement, and then parses it
+func (config *TargetConfig) parseStatement(st Statement, pst *ParseState) (Node, error) {
+	if len(st) == 0 {
+		return nil, st.errorf(0, "empty statement")
+	}
+	// Reduce until there is nothing more to reduce
+	for {
+		reduced, err := config.reduce(st, pst)
+		if err != nil {
+			return nil, err
+		}
+		if len(reduced) == len(st) {
+			break
+		}
+		st = reduced
+	}
+	st, err := config.foldConstants(st, pst)
+	if err != nil {
+		return nil, err
+	}
+	return config.parse(config.resolveLocals(st, pst))
+}
+
+// resolveLocals replaces the names of local variables in the current function with their addresses on the stack, like [rbp-8],
+// and the names of parameters with the registers or addresses they are passed in, like rdi or [ebp+8]
+func (config *TargetConfig) resolveLocals(st Statement, pst *ParseState) Statement {
+	if (st[0].T == KEYWORD) && ((st[0].Value == "local") || (st[0].Value == "fun")) {
+		return st
+	}
+	for i, tok := range st {
+		if tok.T != VALIDNAME {
+			continue
+		}
+		if num, ok := pst.frame.params[tok.Value]; ok {
+			if param, err := config.paramnum2reg(num); err == nil && strings.HasPrefix(param, "[") {
+				st[i] = Token{MEMEXP, param, tok.Position, sizeQualifiers[config.PlatformBits/8]}
+			} else if err == nil {
+				st[i] = Token{REGISTER, param, tok.Position, ""}
+			}
+		} else if offset, ok := pst.frame.locals[tok.Value]; ok {
+			// The size qualifier is kept, for when the size of the memory operand is needed.
+			// Locals that are larger than a register are used one register at a time.
+			st[i] = Token{MEMEXP, config.localAddress(offset), tok.Position, config.localQualifier(pst.frame.sizes[tok.Value])}
+		}
+	}
+	return st
+}
+
+// parse finds out which type of statement the given tokens are
+func (config *TargetConfig) parse(st Statement) (Node, error) {
+	n := node{st}
+	if (st[0].T == BUILTIN) && ((st[0].Value == "int") || (st[0].Value == "syscall")) {
+		return &Syscall{n, st[0].Value == "int", st[1:]}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "var") && (len(st) >= 3) { // variable / bss declaration
+		if st[1].T != VALIDNAME {
+			return nil, st.errorf(1, "%s is not a valid name for a variable", st[1].Value)
+		}
+		if (st[2].T == VALUE) || (strings.HasPrefix(st[2].Value, "_length_of_")) {
+			return &VarDecl{n, st[1].Value, st[2]}, nil
+		}
+		return nil, st.errorf(2, "variable statements are on the form: \"var x 1024\" for reserving 1024 bytes, not: %s %s %s", st[0].Value, st[1].Value, st[2].Value)
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "local") && (len(st) == 3) { // local variable on the stack
+		if st[1].T != VALIDNAME {
+			return nil, st.errorf(1, "%s is not a valid name for a local variable", st[1].Value)
+		}
+		if st[2].T == VALUE {
+			return &Local{n, st[1].Value, st[2]}, nil
+		}
+		return nil, st.errorf(2, "local variable statements are on the form: \"local x 8\" for reserving 8 bytes on the stack, not: %s %s %s", st[0].Value, st[1].Value, st[2].Value)
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "const") && (len(st) >= 4) { // constant data
+		if st[1].T != VALIDNAME {
+			return nil, st.errorf(1, "%s (or a,b,c,d) is not a valid name for a constant", st[1].Value)
+		}
+		if (st[2].T == ASSIGNMENT) && ((st[3].T == STRING) || (st[3].T == VALUE) || (st[3].T == VALIDNAME)) {
+			return &ConstDecl{n, st[1].Value, st[3:]}, nil
+		}
+		return nil, st.errorf(0, "invalid parameters for constant statement")
+	} else if (len(st) > 2) && (st[0].T == VALIDNAME) && (st[1].T == ASSIGNMENT) {
+		return &DataCopy{n, st[0].Value, st[2].Value}, nil
+	} else if (len(st) > 2) && ((st[1].T == ADDITION) && (st[0].T == VALIDNAME) && (st[2].T == VALIDNAME)) {
+		return &DataAppend{n, st[0].Value, st[2].Value}, nil
+	} else if (st[0].T == BUILTIN) && (st[0].Value == "halt") {
+		return &Halt{n}, nil
+	} else if ((config.PlatformBits == 16) || (config.OS == Windows)) && (st[0].T == BUILTIN) && (st[0].Value == "print") && (len(st) > 1) && (st[1].T == VALIDNAME) {
+		return &Print{n, st[1].Value}, nil
+	} else if ((st[0].T == KEYWORD) && (st[0].Value == "ret")) || ((st[0].T == BUILTIN) && (st[0].Value == "exit")) {
+		ret := &Return{n, st[0].Value == "exit", nil}
+		if (len(st) == 2) && ((st[1].T == VALUE) || (st[1].T == REGISTER) || (st[1].T == MEMEXP) || ((st[1].T == VALIDNAME) && !ret.Exit)) {
+			ret.Value = &st[1]
+		} else if len(st) > 1 {
+			return nil, st.errorf(1, "expected a single value or register after %s", st[0].Value)
+		}
+		return ret, nil
+	} else if (len(st) >= 4) && (st[0].T == KEYWORD) && has([]string{"mem", "membyte", "memword", "memdouble"}, st[0].Value) && (st[1].T == VALUE || st[1].T == VALIDNAME || st[1].T == REGISTER || st[1].T == MEMEXP) && (st[2].T == ASSIGNMENT) && (st[3].T == VALUE || st[3].T == VALIDNAME || st[3].T == REGISTER) {
+		// memory assignment
+		return &MemStore{n, memorySize(st[0].Value), st[1], st[3]}, nil
+	} else if (len(st) >= 4) && (st[0].T == REGISTER) && (st[1].T == ASSIGNMENT) && (st[2].T == KEYWORD) && has([]string{"mem", "readbyte", "readword", "readdouble"}, st[2].Value) && (st[3].T == VALUE || st[3].T == VALIDNAME || st[3].T == REGISTER || st[3].T == MEMEXP) {
+		// assignment from memory to register
+		return &MemLoad{n, memorySize(st[2].Value), st[0], st[3]}, nil
+	} else if (len(st) == 3) && (st[0].T == MEMEXP) && (st[1].T == ASSIGNMENT) && ((st[2].T == VALUE) || (st[2].T == REGISTER)) {
+		// assignment to memory, like to a local variable: x = rax
+		return &MemStore{n, "", st[0], st[2]}, nil
+	} else if (len(st) >= 4) && (st[0].T == REGISTER) && (st[1].T == ASSIGNMENT) && (((st[2].T == KEYWORD) && (st[2].Value == "call")) || ((st[2].T == VALIDNAME) && isArguments(st[3:]))) {
+		// Storing the return value of a function, like "rax = call f" or "rbx = add(rax, 5)"
+		n, err := config.parse(st[2:])
+		if err != nil {
+			return nil, err
+		}
+		call, ok := n.(*Call)
+		if !ok {
+			return nil, st.errorf(2, "expected a function call")
+		}
+		call.st, call.Result = st, &st[0]
+		return call, nil
+	} else if len(st) == 3 && ((st[0].T == REGISTER) || (st[0].T == DISREGARD) || (st[0].Value == "stack") || (st[2].Value == "stack")) {
+		// Statements like "eax = 3" are handled here
+		return parseThreeTokens(st)
+	} else if (len(st) > 3) && (st[1].T == COMPARISON) && (st[3].T == LOGICAL) {
+		// Comparisons joined by && and ||, like "rax == 3 && rbx == 4"
+		cond, err := st.condition(0)
+		if err != nil {
+			return nil, err
+		}
+		return &If{n, cond}, nil
+	} else if (len(st) == 4) && (st[0].T == RESERVED) && (st[1].T == VALUE) && (st[2].T == ASSIGNMENT) && ((st[3].T == VALIDNAME) || (st[3].T == VALUE) || (st[3].T == REGISTER)) {
+		return &ListStore{n, st[0], st[1], st[3]}, nil
+	} else if (len(st) == 4) && (st[0].T == REGISTER) && (st[1].T == ASSIGNMENT) && (st[2].T == RESERVED) && (st[3].T == VALUE) {
+		return &ListLoad{n, st[0], st[2], st[3]}, nil
+	} else if (len(st) == 5) && (st[0].T == RESERVED) && (st[1].T == VALUE) && (st[2].T == ASSIGNMENT) && (st[3].T == RESERVED) && (st[4].T == VALUE) {
+		return &ListCopy{n, st[0], st[1], st[3], st[4]}, nil
+	} else if (len(st) >= 2) && (st[0].T == KEYWORD) && (st[0].Value == "asm") && (st[1].T == VALUE) {
+		targetBits, err := strconv.Atoi(st[1].Value)
+		if err != nil {
+			return nil, st.errorf(1, "%s is not a valid platform bit size (like 32 or 64)", st[1].Value)
+		}
+		return &AsmPassthrough{n, targetBits, st[2:]}, nil
+	} else if (len(st) >= 2) && (st[0].T == KEYWORD) && (st[1].T == VALIDNAME) && (st[0].Value == "fun") {
+		fun := &FunDecl{n, st[1].Value, nil}
+		for i, tok := range st[2:] {
+			if tok.T != VALIDNAME {
+				return nil, st.errorf(i+2, "%s is not a valid name for a parameter", tok.Value)
+			}
+			fun.Params = append(fun.Params, tok.Value)
+		}
+		return fun, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "call") && (len(st) >= 2) {
+		if st[1].T != VALIDNAME {
+			return nil, st.errorf(1, "calling an invalid name: %s", st[1].Value)
+		}
+		for i, tok := range st[2:] {
+			if !isArgument(tok) {
+				return nil, st.errorf(i+2, "%s can not be passed to a function", tok.Value)
+			}
+		}
+		return &Call{n, st[1].Value, false, st[2:], nil}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "counter") && (len(st) == 2) {
+		return &Counter{n, st[1]}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "value") && (len(st) == 2) {
+		return &SetValue{n, st[1]}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "loopwrite") && (len(st) == 1) {
+		return &LoopWrite{n}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "write") && (len(st) == 1) {
+		return &Write{n}, nil
+	} else if (st[0].T == KEYWORD) && ((st[0].Value == "rawloop") || (st[0].Value == "loop")) && ((len(st) == 1) || (len(st) == 2)) {
+		loop := &Loop{n, st[0].Value == "rawloop", nil, ""}
+		if len(st) == 2 {
+			loop.Count = &st[1]
+		}
+		return loop, nil
+	} else if (len(st) >= 2) && (st[0].T == ASMLABEL) && (st[1].T == KEYWORD) && ((st[1].Value == "rawloop") || (st[1].Value == "loop") || (st[1].Value == "while")) {
+		// A named loop, like "outer: loop 10"
+		n, err := config.parse(st[1:])
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(st[0].Value, ":")
+		switch loop := n.(type) {
+		case *Loop:
+			loop.st, loop.Name = st, name
+		case *While:
+			loop.st, loop.Name = st, name
+		}
+		return n, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "while") && (len(st) >= 4) {
+		cond, err := st.condition(1)
+		if err != nil {
+			return nil, err
+		}
+		return &While{n, cond, ""}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "address") && (len(st) == 2) {
+		return &Address{n, st[1]}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "bootable") && (len(st) == 1) {
+		return &Bootable{n}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "extern") && (len(st) == 2) {
+		if st[1].T != VALIDNAME {
+			return nil, st.errorf(1, "extern with invalid name: %s", st[1].Value)
+		}
+		return &Extern{n, st[1].Value}, nil
+	} else if (st[0].T == KEYWORD) && ((st[0].Value == "break") || (st[0].Value == "continue")) {
+		return parseLoopJump(st)
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "elif") && (len(st) >= 4) {
+		cond, err := st.condition(1)
+		if err != nil {
+			return nil, err
+		}
+		return &Elif{n, cond}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "else") && (len(st) == 1) {
+		return &Else{n}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "endless") && (len(st) == 1) {
+		return &Endless{n}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "end") && (len(st) == 1) {
+		return &End{n}, nil
+	} else if (st[0].T == VALIDNAME) && isArguments(st[1:]) {
+		// Just a name, possibly followed by arguments, assume it's a function call
+		return &Call{n, st[0].Value, true, st[1:], nil}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "noret") {
+		return &NoRet{n}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "inline_c") {
+		return &InlineC{n}, nil
+	} else if (st[0].T == KEYWORD) && (st[0].Value == "const") {
+		return nil, st.errorf(0, "incomprehensible constant")
+	} else if st[0].T == BUILTIN {
+		return nil, st.errorf(0, "unhandled builtin: %s", st[0].Value)
+	} else if st[0].T == KEYWORD {
+		return nil, st.errorf(0, "unhandled keyword: %s", st[0].Value)
+	}
+	return nil, st.errorf(0, "unfamiliar statement layout")
+}
+
+// isArgument checks if the given token can be passed as an argument to a function
+func isArgument(tok Token) bool {
+	return (tok.T == REGISTER) || (tok.T == VALUE) || (tok.T == VALIDNAME) || (tok.T == MEMEXP)
+}
+
+// isArguments checks if all the given tokens can be passed as arguments to a function
+func isArguments(st Statement) bool {
+	for _, tok := range st {
+		if !isArgument(tok) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLoopJump parses break and continue, that take an optional loop name and an optional comparison,
+// like "break", "break outer", "continue rax == 3" or "continue outer rax == 3"
+func parseLoopJump(st Statement) (Node, error) {
+	n := node{st}
+	rest := st[1:]
+	label := ""
+	if (len(rest) > 0) && (rest[0].T == VALIDNAME) && ((len(rest) == 1) || (rest[1].T != COMPARISON)) {
+		label = rest[0].Value
+		rest = rest[1:]
+	}
+	var cond *Condition
+	if (len(rest) >= 3) && (rest[1].T == COMPARISON) {
+		c, err := rest.condition(0)
+		if err != nil {
+			return nil, err
+		}
+		cond = &c
+	} else if len(rest) != 0 {
+		return nil, st.errorf(1, "%s takes an optional loop name and an optional comparison, like: %s outer rax == 3", st[0].Value, st[0].Value)
+	}
+	if st[0].Value == "break" {
+		return &Break{n, label, cond}, nil
+	}
+	return &Continue{n, label, cond}, nil
+}
+
+// parseThreeTokens parses statements that consists of three tokens, like "rax += 3" or "rax -> stack"
+func parseThreeTokens(st Statement) (Node, error) {
+	n := node{st}
+	if st[1].T == COMPARISON {
+		cond, err := st.condition(0)
+		if err != nil {
+			return nil, err
+		}
+		return &If{n, cond}, nil
+	} else if (st[0].T == REGISTER) && (st[1].T == ASSIGNMENT) && (st[2].T == VALUE || st[2].T == VALIDNAME || st[2].T == REGISTER || st[2].T == MEMEXP) {
+		return &Assign{n, st[0], st[2]}, nil
+	} else if st[0].T == DISREGARD {
+		return &Disregard{n, st[2]}, nil
+	} else if ((st[0].T == REGISTER) || (st[0].Value == "stack") || (st[0].T == VALUE)) && (st[1].T == ARROW) && ((st[2].T == REGISTER) || (st[2].Value == "stack")) {
+		// push and pop
+		if (st[0].Value == "stack") && (st[2].Value == "stack") {
+			return nil, st.errorf(0, "can't pop and push to stack at the same time")
+		} else if (st[2].Value != "stack") && (st[0].Value != "stack") && ((st[0].T != REGISTER) || (st[2].T != REGISTER)) {
+			return nil, st.errorf(0, "unrecognized stack expression")
+		}
+		return &StackOp{n, st[0], st[2]}, nil
+	}
+	switch st[1].T {
+	case ADDITION, SUBTRACTION, MULTIPLICATION, DIVISION, MODULO:
+		if (st[2].T == REGISTER) || (st[2].T == VALUE) || (st[2].T == MEMEXP) {
+			return &Operation{n, st[0], st[1].T, st[2]}, nil
+		}
+	case AND, OR, XOR, ROL, ROR, SHL, SHR, SAR, XCHG, OUT:
+		if (st[2].T == VALUE) || (st[2].T == MEMEXP) || (st[2].T == REGISTER) {
+			return &Operation{n, st[0], st[1].T, st[2]}, nil
+		}
+	case IN:
+		if (st[2].T == MEMEXP) || (st[2].T == REGISTER) {
+			return &Operation{n, st[0], st[1].T, st[2]}, nil
+		}
+	}
+	return nil, st.errorf(1, "unfamiliar 3-token expression")
+}
+
+// memorySize returns the size qualifier for the given memory keyword, like "BYTE" for "membyte"
+func memorySize(keyword string) string {
+	switch keyword {
+	case "membyte", "readbyte":
+		return "BYTE"
+	case "memword", "readword":
+		return "WORD"
+	case "memdouble", "readdouble":
+		return "DOUBLE"
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package battlestarlib
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := "const msg = \"Hi\"\nvar buf 64\nfun main\nrax = len(msg)\nrbx += 2\nloop 4\nmembyte rdi = 65\nend\nrax == 3\nrax -> stack\nend\nsyscall(60, 0)\nend\n"
+	tokens, err := config.Tokenize(program, " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := config.Parse(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"*battlestarlib.ConstDecl", "*battlestarlib.VarDecl", "*battlestarlib.FunDecl", "*battlestarlib.Assign", "*battlestarlib.Operation", "*battlestarlib.Loop", "*battlestarlib.MemStore", "*battlestarlib.End", "*battlestarlib.If", "*battlestarlib.StackOp", "*battlestarlib.End", "*battlestarlib.Syscall", "*battlestarlib.End"}
+	if len(nodes) != len(expected) {
+		t.Fatalf("expected %d statements, got %d", len(expected), len(nodes))
+	}
+	for i, n := range nodes {
+		if typeName := fmt.Sprintf("%T", n); typeName != expected[i] {
+			t.Errorf("statement %d: expected %s, got %s", i, expected[i], typeName)
+		}
+		if n.Pos().Line != uint(i+1) {
+			t.Errorf("statement %d: expected line %d, got %s", i, i+1, n.Pos())
+		}
+	}
+	if a := nodes[3].(*Assign); a.Register.Value != "rax" || a.Value.Value != "_length_of_msg" {
+		t.Errorf("expected len(msg) to be reduced, got %s = %s", a.Register.Value, a.Value.Value)
+	}
+	if op := nodes[4].(*Operation); op.Op != ADDITION || op.Operand.Value != "2" {
+		t.Errorf("unexpected operation: %s %s", op.Op, op.Operand.Value)
+	}
+	if ms := nodes[6].(*MemStore); ms.Size != "BYTE" || ms.Address.Value != "rdi" || ms.Value.Value != "65" {
+		t.Errorf("unexpected memory assignment: %s %s %s", ms.Size, ms.Address.Value, ms.Value.Value)
+	}
+
+	// Generating code from the parsed statements should give the same result as compiling the tokens
+	constants, asmcode, err := config.Generate(nodes, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedConstants, expectedAsmcode, err := config.CompileTokens(tokens, NewProgramState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if constants != expectedConstants || asmcode != expectedAsmcode {
+		t.Errorf("generated code differs from compiled code:\n%s\n%s\n---\n%s\n%s", constants, asmcode, expectedConstants, expectedAsmcode)
+	}
+
+	// Invalid statements are reported with their position
+	tokens, err = config.Tokenize("fun main\nconst 3 = 4\nend\n", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Parse(tokens); err == nil {
+		t.Error("expected an error for an invalid constant name")
+	} else if diags, ok := err.(Diagnostics); !ok || len(diags) != 1 || diags[0].Line != 2 {
+		t.Errorf("expected one error on line 2, got %v", err)
+	}
+}
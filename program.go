@@ -2,6 +2,7 @@ package battlestarlib
 
 import (
 	"strconv"
+	"strings"
 )
 
 type (
@@ -10,30 +11,86 @@ type (
 
 	// ProgramState is the state of the current position in this program, when compiling
 	ProgramState struct {
-		surpriseEndingWithExit bool           // To keep track of function blocks that are ended with "exit"
-		loopStep               int            // To keep track of if rep should use stosb or stosw (and stepsize in loops in general)
-		loopNameCounter        int            // To keep track of which generated label names have already been used
-		ifNameCounter          int            // To keep track of which generated label names have already been used
-		definedNames           []string       // all defined variables/constants/functions
-		variables              map[string]int // map of variable names and reserved bytes
-		inFunction             string         // name of the function we are currently in
-		inLoop                 string         // name of the loop we are currently in
-		inIfBlock              string         // name of the if block we are currently in
-		endless                bool           // ending the program with endless keyword?
+		surpriseEndingWithExit bool              // To keep track of function blocks that are ended with "exit" or "ret"
+		loopStep               int               // To keep track of if rep should use stosb or stosw (and stepsize in loops in general)
+		loopNameCounter        int               // To keep track of which generated label names have already been used
+		ifNameCounter          int               // To keep track of which generated label names have already been used
+		skipNameCounter        int               // To keep track of which generated label names have already been used
+		definedNames           []string          // all defined variables/constants/functions
+		variables              map[string]int    // map of variable names and reserved bytes
+		constants              map[string]string // map of numeric constant names and values, for constant expressions
+		inFunction             string            // name of the function we are currently in
+		externs                []string          // all declared external symbols, like printf
+		imports                []string          // the Windows API functions that have been declared as external so far
+		blocks                 []*block          // the if blocks and loops we are currently in, the innermost one last
+		frame                  *frame            // the local variables of the function we are currently in
+		pastLocals             bool              // has a statement other than a local variable declaration been found in the current function?
+		endless                bool              // ending the program with endless keyword?
+		bootableKernel         bool              // has the "bootable" keyword been encountered?
+		inlineC                bool              // currently in a block of inline C?
+		dataNotValueTypes      []string          // all defined constants that are data (x: db 1,2,3,4...)
+		diagnostics            Diagnostics       // errors, warnings and notes found so far
 	}
 )
 
-var (
-	// Global program state
-	dataNotValueTypes []string          // all defined constants that are data (x: db 1,2,3,4...)
-	types             map[string]string // type of the defined names
+// blockKind is the type of a block that is ended with "end"
+type blockKind int
+
+const (
+	ifBlock blockKind = iota
+	loopBlock
 )
 
+// block is an if block or a loop that has been started, but not yet ended
+type block struct {
+	kind         blockKind
+	label        string // generated label name, like "if1" or "l1"
+	name         string // for loops, the optional name given in the source code, like "outer"
+	next         string // for if blocks, where to jump when the current comparison is false
+	done         string // for if blocks with elif or else, the label at the very end of the block
+	hasElse      bool   // for if blocks, has "else" been encountered?
+	branchNumber int    // for if blocks, the number of "elif" branches so far
+}
+
+// frame keeps track of the local variables of a function, which are stored on the stack, below the base pointer
+type frame struct {
+	locals map[string]int // local variable names and their offsets below the base pointer
+	sizes  map[string]int // local variable names and their sizes, in bytes
+	size   int            // the number of bytes used by local variables so far
+	params map[string]int // parameter names and their positions, starting at 0
+}
+
+func newFrame() *frame {
+	return &frame{locals: make(map[string]int), sizes: make(map[string]int), params: make(map[string]int)}
+}
+
+// bind gives the parameters of a function their positions
+func (f *frame) bind(params []string) {
+	for i, name := range params {
+		f.params[name] = i
+	}
+}
+
+// declare places a local variable of the given size in the frame, aligned to its size (but at most 8 bytes),
+// and returns its offset below the base pointer
+func (f *frame) declare(name string, size int) int {
+	align := 1
+	for (align < 8) && (align*2 <= size) {
+		align *= 2
+	}
+	f.size = (f.size + size + align - 1) / align * align
+	f.locals[name] = f.size
+	f.sizes[name] = size
+	return f.size
+}
+
 const (
 	// For the types of loops that does not save and restore the counter before and after the loop body
 	rawloopPrefix = "r_"
 	// For the types of loops that loop forever
 	endlessloopPrefix = "e_"
+	// For loops that run for as long as a condition is true
+	whileloopPrefix = "w_"
 )
 
 // NewProgramState returns a new state struct that is used when the program is compiled
@@ -42,6 +99,9 @@ func NewProgramState() *ProgramState {
 	// Initialize global maps and slices
 	ps.definedNames = make([]string, 0)
 	ps.variables = make(map[string]int)
+	ps.constants = make(map[string]string)
+	ps.frame = newFrame()
+	ps.dataNotValueTypes = make([]string, 0)
 	return &ps
 }
 
@@ -54,3 +114,50 @@ func (p *ProgramState) newIfLabel() string {
 	p.ifNameCounter++
 	return "if" + strconv.Itoa(p.ifNameCounter)
 }
+
+// pushBlock starts a new if block or loop
+func (p *ProgramState) pushBlock(b *block) {
+	p.blocks = append(p.blocks, b)
+}
+
+// popBlock ends the innermost if block or loop, and returns it
+func (p *ProgramState) popBlock() *block {
+	b := p.innermostBlock()
+	if b != nil {
+		p.blocks = p.blocks[:len(p.blocks)-1]
+	}
+	return b
+}
+
+// innermostBlock returns the innermost if block or loop, or nil
+func (p *ProgramState) innermostBlock() *block {
+	if len(p.blocks) == 0 {
+		return nil
+	}
+	return p.blocks[len(p.blocks)-1]
+}
+
+// findLoop returns the position in the block stack of the innermost loop with the given name,
+// or of the innermost loop if the name is empty. -1 is returned if there is no such loop.
+func (p *ProgramState) findLoop(name string) int {
+	for i := len(p.blocks) - 1; i >= 0; i-- {
+		if (p.blocks[i].kind == loopBlock) && ((name == "") || (p.blocks[i].name == name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// newSkipLabel returns a label for jumping past code that should only run if a comparison is true
+func (p *ProgramState) newSkipLabel() string {
+	p.skipNameCounter++
+	return "skip" + strconv.Itoa(p.skipNameCounter)
+}
+
+// savesCounter checks if the block is a loop that saves the counter on the stack
+func (b *block) savesCounter() bool {
+	rawloop := strings.HasPrefix(b.label, rawloopPrefix)     // Is it a rawloop?
+	endless := strings.HasPrefix(b.label, endlessloopPrefix) // Is it endless?
+	while := strings.HasPrefix(b.label, whileloopPrefix)     // Is it a while loop?
+	return (b.kind == loopBlock) && !rawloop && !endless && !while
+}
@@ -1,6 +1,7 @@
 package battlestarlib
 
 import (
+	"sync"
 	"testing"
 )
 
@@ -11,3 +12,63 @@ func TestProgramState(t *testing.T) {
 		t.Errorf("Error initializing program state.\n")
 	}
 }
+
+// compile tokenizes and compiles the given program, with a fresh program state
+func compile(config *TargetConfig, program string) (string, error) {
+	tokens, err := config.Tokenize(program, " ")
+	if err != nil {
+		return "", err
+	}
+	ps := NewProgramState()
+	constants, asmcode, err := config.CompileTokens(config.AddExitTokenIfMissing(tokens), ps)
+	if err != nil {
+		return "", err
+	}
+	asmcode, err = config.AddStartingPointIfMissing(asmcode, ps)
+	if err != nil {
+		return "", err
+	}
+	return constants + "\n" + asmcode, nil
+}
+
+func TestConcurrentCompilation(t *testing.T) {
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	programs := []string{
+		"const hello = \"Hello, World!\\n\"\nfun main\nprint(hello)\nend\n",
+		"const msg = \"Hi\"\nvar buf 64\nfun main\nbuf = msg\nprint(buf)\nexit 3\nend\n",
+		"fun main\nrax = 3\nloop 4\nrbx += 2\nend\nrax == 3\nrbx = 4\nend\nexit\nend\n",
+		"bootable\nfun main\nhalt\nend\n",
+	}
+	// Compile each program once, sequentially, to have something to compare with
+	expected := make([]string, len(programs))
+	for i, program := range programs {
+		if expected[i], err = compile(config, program); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Compile all the programs several times, concurrently, using the same configuration
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		for i, program := range programs {
+			wg.Add(1)
+			go func(i int, program string) {
+				defer wg.Done()
+				asmcode, err := compile(config, program)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if asmcode != expected[i] {
+					t.Errorf("Concurrent compilation of program #%d differs from the sequential one:\n%s\n", i, asmcode)
+				}
+			}(i, program)
+		}
+	}
+	wg.Wait()
+	if config.BootableKernel {
+		t.Errorf("Compiling a program should not change the target configuration\n")
+	}
+}
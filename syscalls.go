@@ -0,0 +1,148 @@
+package battlestarlib
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// syscallTable contains the numbers of the system calls for a target, by name.
+// For DOS, the numbers are the functions of interrupt 21h, given in ah.
+type syscallTable struct {
+	numbers map[string]int
+	hex     bool // are the numbers written in hexadecimal?
+}
+
+// ref: arch/x86/entry/syscalls/syscall_64.tbl and syscall_32.tbl in the Linux source code
+var (
+	linuxSyscalls64 = &syscallTable{numbers: map[string]int{
+		"read": 0, "write": 1, "open": 2, "close": 3, "brk": 12, "getpid": 39, "fork": 57, "execve": 59,
+		"exit": 60, "kill": 62, "mkdir": 83, "unlink": 87,
+	}}
+	linuxSyscalls32 = &syscallTable{numbers: map[string]int{
+		"exit": 1, "fork": 2, "read": 3, "write": 4, "open": 5, "close": 6, "unlink": 10, "execve": 11,
+		"getpid": 20, "kill": 37, "mkdir": 39, "brk": 45,
+	}}
+)
+
+// The system calls that have the same numbers on FreeBSD, OpenBSD and macOS, on both 32-bit and 64-bit platforms.
+// ref: sys/kern/syscalls.master in the FreeBSD and OpenBSD source code, and bsd/kern/syscalls.master in XNU
+var bsdSyscalls = &syscallTable{numbers: map[string]int{
+	"exit": 1, "fork": 2, "read": 3, "write": 4, "open": 5, "close": 6, "unlink": 10, "getpid": 20,
+	"execve": 59, "mkdir": 136,
+}}
+
+// On 64-bit macOS, the BSD system calls are found in the class of system calls that starts at 0x2000000.
+// ref: https://opensource.apple.com/source/xnu/xnu-7195.81.3/osfmk/mach/i386/syscall_sw.h
+var macOSSyscalls64 = func() *syscallTable {
+	numbers := make(map[string]int)
+	for name, number := range bsdSyscalls.numbers {
+		numbers[name] = 0x2000000 + number
+	}
+	return &syscallTable{numbers: numbers, hex: true}
+}()
+
+// The functions of the DOS interrupt 21h
+// ref: http://spike.scu.edu.au/~barry/interrupts.html
+var dosFunctions = &syscallTable{numbers: map[string]int{
+	"mkdir": 0x39, "open": 0x3d, "close": 0x3e, "read": 0x3f, "write": 0x40, "unlink": 0x41, "exit": 0x4c,
+}, hex: true}
+
+// syscalls returns the system call table for the target, or nil if there are no system calls, like on Windows.
+// Bootable kernels use the Linux numbers, like before there were several operating systems to choose from.
+func (config *TargetConfig) syscalls() *syscallTable {
+	switch {
+	case config.OS == Windows:
+		return nil
+	case config.PlatformBits == 16:
+		return dosFunctions
+	case (config.OS == MacOS) && (config.PlatformBits == 64):
+		return macOSSyscalls64
+	case config.OS.isBSD():
+		return bsdSyscalls
+	case config.PlatformBits == 32:
+		return linuxSyscalls32
+	}
+	return linuxSyscalls64
+}
+
+// format returns a system call number the way it is written in the assembly code
+func (table *syscallTable) format(number int) string {
+	if table.hex {
+		return fmt.Sprintf("0x%x", number)
+	}
+	return strconv.Itoa(number)
+}
+
+// syscallNumber returns the number of the system call with the given name, like "write", for the target
+func (config *TargetConfig) syscallNumber(name string) (string, bool) {
+	table := config.syscalls()
+	if table == nil {
+		return "", false
+	}
+	number, ok := table.numbers[name]
+	if !ok {
+		return "", false
+	}
+	return table.format(number), true
+}
+
+// syscallName returns the name of the system call with the given number, like "write" for "1" on 64-bit Linux
+func (config *TargetConfig) syscallName(number string) (string, bool) {
+	table := config.syscalls()
+	if table == nil {
+		return "", false
+	}
+	negative, magnitude, err := parseLiteral(number)
+	if err != nil || negative {
+		return "", false
+	}
+	for name, n := range table.numbers {
+		if uint64(n) == magnitude {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// syscallInterrupt returns the interrupt that is used for system calls on the target, or "" if the syscall instruction is used
+func (config *TargetConfig) syscallInterrupt() string {
+	switch config.PlatformBits {
+	case 16:
+		return "0x21"
+	case 32:
+		return "0x80"
+	}
+	return ""
+}
+
+// isSyscallInterrupt checks if the given interrupt number is the one that is used for system calls on the target
+func (config *TargetConfig) isSyscallInterrupt(interrupt string) bool {
+	_, a, err := parseLiteral(interrupt)
+	if err != nil {
+		// Interrupts are assumed to be given in hexadecimal, like "80" for "0x80"
+		if _, a, err = parseLiteral("0x" + interrupt); err != nil {
+			return false
+		}
+	}
+	_, b, err := parseLiteral(config.syscallInterrupt())
+	return (err == nil) && (a == b)
+}
+
+// namedSyscall returns the tokens for a system call that is given by name, like sys.write,
+// which is syscall(1, ...) on 64-bit Linux and int(0x80, 4, ...) on 32-bit Linux.
+// pos is the position of the "sys.write" word in the source code.
+func (config *TargetConfig) namedSyscall(name string, pos Position) ([]Token, error) {
+	word := Token{UNKNOWN, "sys." + name, pos, ""}
+	if config.PlatformBits == 16 {
+		return nil, newCompileError(word, "named system calls are not supported on 16-bit platforms, use int(0x21, ...) instead")
+	}
+	number, ok := config.syscallNumber(name)
+	if !ok {
+		return nil, newCompileError(word, "unknown system call for %s: %s", config, name)
+	}
+	numberToken := Token{VALUE, number, pos.at(4, len(name)), ""}
+	if interrupt := config.syscallInterrupt(); interrupt != "" {
+		return []Token{{BUILTIN, "int", pos.at(0, 3), ""}, {VALUE, interrupt, pos.at(0, 3), ""}, numberToken}, nil
+	}
+	return []Token{{BUILTIN, "syscall", pos.at(0, 3), ""}, numberToken}, nil
+}
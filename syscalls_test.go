@@ -0,0 +1,58 @@
+package battlestarlib
+
+import (
+	"testing"
+)
+
+func TestNamedSyscalls(t *testing.T) {
+	program := "const msg = \"Hi\"\nfun main\nsyscall(write, 1, msg, len(msg))\nsys.write(1, msg, len(msg))\nsys.getpid()\nend\n"
+	tests := []struct {
+		target   string
+		expected []string
+	}{
+		{"x86_64-linux", []string{"system call: write", "mov rax, 1", "system call: write", "mov rax, 1", "system call: getpid", "mov rax, 39"}},
+		{"i386-linux", []string{"mov eax, 4", "interrupt 0x80: write", "mov eax, 4", "int 0x80", "interrupt 0x80: getpid", "mov eax, 20", "int 0x80"}},
+		{"x86_64-darwin", []string{"mov rax, 0x2000004", "mov rax, 0x2000004", "mov rax, 0x2000014"}},
+		{"x86_64-openbsd", []string{"mov rax, 4", "mov rax, 4", "mov rax, 20"}},
+	}
+	for _, test := range tests {
+		config, err := ParseTarget(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		asmcode, err := compile(config, program)
+		if err != nil {
+			t.Errorf("%s: %v", test.target, err)
+			continue
+		}
+		expectInOrder(t, asmcode, test.expected)
+	}
+
+	// Numbers are also annotated with the name of the system call
+	config, err := NewTargetConfig(64, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asmcode, err := compile(config, "fun main\nsyscall(60, 0)\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{";--- system call: exit ---", "mov rax, 60"})
+
+	if _, err := compile(config, "fun main\nsys.fly(1)\nend\n"); err == nil {
+		t.Error("expected an error for an unknown system call")
+	}
+
+	// Names that are not in the table are left for the assembler, since they may be defined in asm lines
+	asmcode, err = compile(config, "asm 64 SYS_FLY equ 99\nfun main\nsyscall(SYS_FLY, 1)\nend\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInOrder(t, asmcode, []string{"SYS_FLY equ 99", "mov rax, SYS_FLY", "mov rdi, 1", "syscall"})
+	if config, err = NewTargetConfig(16, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compile(config, "fun main\nsys.write(1)\nend\n"); err == nil {
+		t.Error("expected an error for named system calls on 16-bit platforms")
+	}
+}
@@ -0,0 +1,79 @@
+package battlestarlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The names of the supported architectures in target triples, and their bit sizes
+var archBits = map[string]int{"x86_64": 64, "amd64": 64, "i386": 32, "i486": 32, "i586": 32, "i686": 32, "i8086": 16, "8086": 16}
+
+// The names that are used when a target is written out, for each bit size
+var archNames = map[int]string{64: "x86_64", 32: "i386", 16: "i8086"}
+
+// Other names for the operating systems, that are also accepted in target triples
+var osAliases = map[string]OS{"macos": MacOS, "osx": MacOS, "win32": Windows, "win64": Windows, "msdos": DOS}
+
+// bootName is used instead of the name of the operating system for bootable 16-bit programs,
+// which are built for DOS, but run without it, like in "i8086-boot"
+const bootName = "boot"
+
+// ParseTarget returns a new TargetConfig for the given target, like "x86_64-linux", "i386-linux",
+// "i8086-dos", "i8086-boot", "i386-multiboot", "x86_64-darwin", "x86_64-windows" or "x86_64-openbsd".
+func ParseTarget(target string) (*TargetConfig, error) {
+	fields := strings.Split(strings.ToLower(target), "-")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("Error: Invalid target, expected architecture-os, like x86_64-linux: %s", target)
+	}
+	bits, ok := archBits[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("Error: Unsupported architecture: %s", fields[0])
+	}
+	if fields[1] == bootName {
+		return NewTargetConfigForOS(bits, DOS, true)
+	}
+	os, ok := osAliases[fields[1]]
+	if !ok {
+		found := false
+		for o, name := range osNames {
+			if name == fields[1] {
+				os, found = o, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Error: Unsupported operating system: %s", fields[1])
+		}
+	}
+	return NewTargetConfigForOS(bits, os, os == Multiboot)
+}
+
+// String returns the target as architecture-os, like "x86_64-linux". For any TargetConfig that is
+// returned by NewTargetConfigForOS, ParseTarget gives back the same target for this string.
+func (config *TargetConfig) String() string {
+	if (config.OS == DOS) && config.BootableKernel {
+		return archNames[config.PlatformBits] + "-" + bootName
+	}
+	return archNames[config.PlatformBits] + "-" + config.OS.String()
+}
+
+// ABI returns the short name of the calling convention of the target, like "sysv", "ms", "cdecl" or "near"
+func (config *TargetConfig) ABI() string {
+	if cc := config.callingConvention(); cc != nil {
+		return cc.name
+	}
+	return ""
+}
+
+// ObjectFormat returns the output format that NASM should use for the target, like "elf64", "macho64", "win64" or "bin"
+func (config *TargetConfig) ObjectFormat() string {
+	switch {
+	case config.PlatformBits == 16:
+		return "bin"
+	case config.OS == MacOS:
+		return fmt.Sprintf("macho%d", config.PlatformBits)
+	case config.OS == Windows:
+		return fmt.Sprintf("win%d", config.PlatformBits)
+	}
+	return fmt.Sprintf("elf%d", config.PlatformBits)
+}
@@ -0,0 +1,86 @@
+package battlestarlib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target       string
+		bits         int
+		os           OS
+		abi          string
+		objectFormat string
+		start        string
+	}{
+		{"x86_64-linux", 64, Linux, "sysv", "elf64", "_start"},
+		{"i386-linux", 32, Linux, "cdecl", "elf32", "_start"},
+		{"i8086-dos", 16, DOS, "near", "bin", "_start"},
+		{"i8086-boot", 16, DOS, "near", "bin", "_start"},
+		{"i386-multiboot", 32, Multiboot, "cdecl", "elf32", "_start"},
+		{"x86_64-darwin", 64, MacOS, "sysv", "macho64", "_main"},
+		{"x86_64-windows", 64, Windows, "ms", "win64", "main"},
+		{"i386-windows", 32, Windows, "cdecl", "win32", "_main"},
+		{"x86_64-freebsd", 64, FreeBSD, "sysv", "elf64", "_start"},
+		{"x86_64-openbsd", 64, OpenBSD, "sysv", "elf64", "_start"},
+	}
+	for _, test := range tests {
+		config, err := ParseTarget(test.target)
+		if err != nil {
+			t.Errorf("%s: %v", test.target, err)
+			continue
+		}
+		if config.PlatformBits != test.bits || config.OS != test.os || config.ABI() != test.abi || config.ObjectFormat() != test.objectFormat || config.LinkerStartFunction != test.start {
+			t.Errorf("%s: got %d-bit %s, %s, %s and %s", test.target, config.PlatformBits, config.OS, config.ABI(), config.ObjectFormat(), config.LinkerStartFunction)
+		}
+		if config.BootableKernel != ((test.os == Multiboot) || (test.target == "i8086-boot")) {
+			t.Errorf("%s: expected a bootable kernel only for multiboot and boot", test.target)
+		}
+		if s := config.String(); s != test.target {
+			t.Errorf("expected %s to round-trip, got %s", test.target, s)
+		}
+	}
+
+	// Other names for the architecture and the operating system
+	if config, err := ParseTarget("amd64-macos"); err != nil || config.String() != "x86_64-darwin" {
+		t.Errorf("expected amd64-macos to be the same as x86_64-darwin, got %v, %v", config, err)
+	}
+	for _, target := range []string{"", "x86_64", "arm64-linux", "x86_64-plan9", "i8086-linux", "x86_64-dos", "i8086-multiboot", "x86_64-pc-linux"} {
+		if _, err := ParseTarget(target); err == nil {
+			t.Errorf("expected an error for the target %q", target)
+		}
+	}
+
+	// Bootable kernels made with NewTargetConfig are multiboot targets
+	config, err := NewTargetConfig(32, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := config.String(); s != "i386-multiboot" {
+		t.Errorf("expected i386-multiboot, got %s", s)
+	}
+	if _, err := NewTargetConfigForOS(64, Linux, true); err == nil {
+		t.Error("expected an error for a bootable Linux kernel")
+	}
+
+	// Every target that can be made must be the same after writing it out and parsing it back
+	for _, bits := range []int{16, 32, 64} {
+		for os := range osNames {
+			for _, bootable := range []bool{false, true} {
+				config, err := NewTargetConfigForOS(bits, os, bootable)
+				if err != nil {
+					continue
+				}
+				parsed, err := ParseTarget(config.String())
+				if err != nil {
+					t.Errorf("%s: %v", config, err)
+					continue
+				}
+				if !reflect.DeepEqual(parsed, config) {
+					t.Errorf("%s: expected %+v, got %+v", config, *config, *parsed)
+				}
+			}
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package battlestarlib
 
 import (
-	"log"
+	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -38,16 +39,16 @@ const (
 	XCHG           = 28
 	OUT            = 29
 	IN             = 30
+	LOGICAL        = 31 // && or ||, between comparisons
+	SAR            = 32 // arithmetic shift right, for signed numbers
+	MODULO         = 33
+	EXPROP         = 34  // an operator or parenthesis in a constant expression, like * or (
 	SEP            = 127 // statement separator
 	UNKNOWN        = 255
 )
 
 var (
-	debug          = true
-	tokenDebug     = false
-	newTokensDebug = true
-
-	tokenToString = TokenDescriptions{REGISTER: "register", ASSIGNMENT: "assignment", VALUE: "value", VALIDNAME: "name", SEP: ";", UNKNOWN: "?", KEYWORD: "keyword", STRING: "string", BUILTIN: "built-in", DISREGARD: "disregard", RESERVED: "reserved", VARIABLE: "variable", ADDITION: "addition", SUBTRACTION: "subtraction", MULTIPLICATION: "multiplication", DIVISION: "division", COMPARISON: "comparison", ARROW: "stack operation", MEMEXP: "address expression", ASMLABEL: "assembly label", AND: "and", XOR: "xor", OR: "or", ROL: "rol", ROR: "ror", CONCAT: "concatenation", SEGOFS: "segment+offset", SHL: "shl", SHR: "shr", QUAL: "qualifier", XCHG: "xchg", OUT: "out", IN: "in"}
+	tokenToString = TokenDescriptions{REGISTER: "register", ASSIGNMENT: "assignment", VALUE: "value", VALIDNAME: "name", SEP: ";", UNKNOWN: "?", KEYWORD: "keyword", STRING: "string", BUILTIN: "built-in", DISREGARD: "disregard", RESERVED: "reserved", VARIABLE: "variable", ADDITION: "addition", SUBTRACTION: "subtraction", MULTIPLICATION: "multiplication", DIVISION: "division", COMPARISON: "comparison", ARROW: "stack operation", MEMEXP: "address expression", ASMLABEL: "assembly label", AND: "and", XOR: "xor", OR: "or", ROL: "rol", ROR: "ror", CONCAT: "concatenation", SEGOFS: "segment+offset", SHL: "shl", SHR: "shr", QUAL: "qualifier", XCHG: "xchg", OUT: "out", IN: "in", LOGICAL: "logical operator", SAR: "sar", MODULO: "modulo", EXPROP: "expression operator"}
 	// see also the top of language.go, when adding tokens
 )
 
@@ -55,11 +56,20 @@ type (
 	// TokenType is one of the above token constants
 	TokenType int
 
+	// Position is a location in the source code
+	Position struct {
+		File   string // name of the source file, if known
+		Line   uint   // line number, counting from 1
+		Col    uint   // column number, in bytes, counting from 1
+		Offset int    // byte offset from the start of the source code
+		Length int    // length of the token in the source code, in bytes
+	}
+
 	// Token contains everything needed to know about a parsed token
 	Token struct {
 		T     TokenType
 		Value string
-		Line  uint
+		Position
 		extra string // Used when coverting from register to string
 	}
 
@@ -83,8 +93,34 @@ func (tok Token) String() string {
 	} else if haskey(tokenToString, tok.T) {
 		return tokenToString[tok.T] + ":" + tok.Value
 	}
-	log.Fatalln("Error: Unfamiliar token when representing as string: " + tok.Value)
-	return "!?"
+	// Unfamiliar token type
+	return "!?:" + tok.Value
+}
+
+// Represent a Position as a string, like "main.bts:3:7"
+func (pos Position) String() string {
+	s := fmt.Sprintf("%d:%d", pos.Line, pos.Col)
+	if pos.File != "" {
+		s = pos.File + ":" + s
+	}
+	return s
+}
+
+// at returns a position that is the given number of bytes further along on the same line
+func (pos Position) at(n, length int) Position {
+	pos.Col += uint(n)
+	pos.Offset += n
+	pos.Length = length
+	return pos
+}
+
+// setPosition sets the position of all the given tokens, and returns the tokens.
+// Used for tokens that are generated from other tokens, so that they point to the original source.
+func setPosition(tokens []Token, pos Position) []Token {
+	for i := range tokens {
+		tokens[i].Position = pos
+	}
+	return tokens
 }
 
 // Represent a TokenType as a string
@@ -94,16 +130,22 @@ func (toktyp TokenType) String() string {
 	} else if haskey(tokenToString, toktyp) {
 		return tokenToString[toktyp]
 	}
-	log.Fatalln("Error when serializing: Unfamiliar token type when representing tokentype as string: ", int(toktyp))
+	// Unfamiliar token type
 	return "!?"
 }
 
-// Split a string into more tokens and tokenize them
-func (config *TargetConfig) retokenize(word string, sep string) []Token {
+// Split a string into more tokens and tokenize them.
+// pos is the position of the given word in the source code.
+func (config *TargetConfig) retokenize(word string, sep string, pos Position) ([]Token, error) {
 	var newtokens []Token
-	words := strings.Split(word, sep)
+	words := splitOutsideQuotes(word, sep)
+	offset := 0
 	for _, s := range words {
-		tokens := config.Tokenize(s, sep)
+		tokens, err := config.tokenize(s, sep, pos.at(offset, len(s)))
+		offset += len(s) + len(sep)
+		if err != nil {
+			return nil, err
+		}
 		//log.Println("RETOKEN", tokens)
 		for _, t := range tokens {
 			if t.T != SEP {
@@ -111,50 +153,100 @@ func (config *TargetConfig) retokenize(word string, sep string) []Token {
 			}
 		}
 	}
-	return newtokens
+	return newtokens, nil
 }
 
-func logtoken(tok Token) {
-	if tokenDebug {
-		log.Println("TOKEN", tok)
+func (config *TargetConfig) logtoken(tok Token) {
+	config.logf(LogTokens, "TOKEN %v", tok)
+}
+
+func (config *TargetConfig) lognewtokens(tokens []Token) {
+	config.logf(LogTokens, "NEWTOKENS %v", tokens)
+}
+
+// splitParens finds the parentheses of a constant expression at the start and end of a word, like "(4" or "1)".
+// Returns the number of opening parentheses, the rest of the word and the number of closing parentheses.
+// Closing parentheses are only counted if they close one of the given number of open parentheses,
+// so that the end of built-in function calls, like "len(msg)" or "syscall(60, 0)", are left alone.
+func splitParens(word string, parens int) (open int, inner string, close int) {
+	for open < len(word) && word[open] == '(' {
+		open++
 	}
+	inner = word[open:]
+	if strings.Contains(inner, "(") {
+		return 0, word, 0
+	}
+	for close < parens+open && strings.HasSuffix(inner, ")") {
+		inner = inner[:len(inner)-1]
+		close++
+	}
+	return open, inner, close
+}
+
+// Tokenize a string.
+// Lines that can not be tokenized are skipped, and all the problems are returned together as Diagnostics,
+// along with the tokens of the other lines, so that compilation can continue and find more problems.
+func (config *TargetConfig) Tokenize(program, sep string) ([]Token, error) {
+	return config.TokenizeFile("", program, sep)
 }
 
-func lognewtokens(tokens []Token) {
-	if newTokensDebug {
-		log.Println("NEWTOKENS", tokens)
+// TokenizeFile tokenizes a string, where the given filename is used for the positions of the tokens
+func (config *TargetConfig) TokenizeFile(filename, program, sep string) ([]Token, error) {
+	if err := config.check(); err != nil {
+		return nil, err
 	}
+	return config.tokenize(program, sep, Position{File: filename, Line: 1, Col: 1})
 }
 
-// Tokenize a string
-func (config *TargetConfig) Tokenize(program, sep string) []Token {
-	statements := maps(maps(strings.Split(program, "\n"), strings.TrimSpace), removecomments)
+// splitWords splits a statement into words, separated by spaces.
+// Spaces inside string and character literals do not split words.
+// The column offset of each word is also returned.
+func splitWords(statement string) ([]string, []int) {
+	var (
+		words   []string
+		offsets []int
+		offset  int
+	)
+	for _, part := range splitOutsideQuotes(statement, " ") {
+		trimmed := strings.TrimSpace(part)
+		words = append(words, trimmed)
+		offsets = append(offsets, offset+strings.Index(part, trimmed))
+		offset += len(part) + 1
+	}
+	return words, offsets
+}
+
+// tokenize a string, where origin is the position of the first byte of the string in the source code
+func (config *TargetConfig) tokenize(program, sep string, origin Position) ([]Token, error) {
 	tokens := make([]Token, 0)
 	var (
-		t           Token
-		instring    = false // Have we encountered a " for any given statement?
-		constexpr   = false // Are we in a constant expression?
-		varexpr     = false // Are we in a variable expression?
-		collected   string  // Collected string, until end of line
-		inlineC     = false // Are we in parts of the code that are inline_c ... end ?
-		cBlock      = false // Are we in parts of the code that are void ... } ?
-		statementnr uint
+		diags   Diagnostics
+		inlineC = false // Are we in parts of the code that are inline_c ... end ?
+		cBlock  = false // Are we in parts of the code that are void ... } ?
+		linepos = origin
 	)
-	for statementnrInt, statement := range statements {
-		// TODO: Use line number instead of statement number (but statement numbers are better than nothing)
-		statementnr = uint(statementnrInt)
-		words := maps(strings.Split(statement, " "), strings.TrimSpace)
+	for linenr, line := range strings.Split(program, "\n") {
+		if linenr > 0 {
+			// The position of the start of this line
+			linepos.Line++
+			linepos.Col = 1
+		}
+		statement := removecomments(strings.TrimSpace(line))
+		// Where the trimmed statement starts on this line
+		statementpos := linepos.at(len(line)-len(strings.TrimLeft(line, " \t\r\n\v\f")), len(statement))
+		// The position of the next line, in bytes from the start of the source code
+		linepos.Offset += len(line) + 1
+
+		words, offsets := splitWords(statement)
 
 		if len(words) == 0 {
 			continue
 		}
 
 		if words[0] == "void" {
-			if debug {
-				log.Println("Found void, starting C block")
-			}
+			config.logf(LogTokens, "Found void, starting C block")
 			if (len(words) > 1) && (strings.HasPrefix(words[1], "main(")) {
-				log.Println("External main function detected.", words[1])
+				config.logf(LogTokens, "External main function detected: %s", words[1])
 				// Automatically added
 				//log.Println("Remember to add \"extern main\" at the top of the file!")
 			}
@@ -162,25 +254,19 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 			// Skip the start of this type of inline C, don't include "void" as a token
 			continue
 		} else if inlineC && (words[0] == "end") {
-			if debug {
-				log.Println("Found the end of inline C block")
-			}
+			config.logf(LogTokens, "Found the end of inline C block")
 			// End both types of blocks when "end" is encountered
 			inlineC = false
 			cBlock = false
 			// Skip the end keyword of this type of inline C block, don't include "end" as a token
 			continue
 		} else if cBlock && (words[0] == "}") {
-			if debug {
-				log.Println("Found the } of void C block")
-			}
+			config.logf(LogTokens, "Found the } of void C block")
 			cBlock = false
 			// Skip the } keyword of this type of inline C block, don't include "}" as a token
 			continue
 		} else if words[0] == "inline_c" {
-			if debug {
-				log.Println("Found inline_c, starting inline C block")
-			}
+			config.logf(LogTokens, "Found inline_c, starting inline C block")
 			inlineC = true
 			// Skip the start of this type of inline C, don't include "inline_c" as a token
 			continue
@@ -189,218 +275,317 @@ func (config *TargetConfig) Tokenize(program, sep string) []Token {
 			// log.Println("Skipping when tokenizing:", words)
 			continue
 		}
-		// If we are defining a constant, ease up on tokenizing the rest of the line recursively
-		if words[0] == "const" {
-			constexpr = true
-		} else if words[0] == "var" {
-			varexpr = true
+		lineTokens, err := config.tokenizeStatement(statement, words, offsets, statementpos)
+		if err != nil {
+			// Record the error, skip this line and continue with the next one
+			diags = diags.withError(err, statement)
+			continue
 		}
+		tokens = append(tokens, lineTokens...)
+		tokens = append(tokens, Token{SEP, ";", statementpos.at(len(statement), 0), ""})
+	}
+	return tokens, diags.Err()
+}
 
-		// Tokenize the words
-		for _, word := range words {
-			if word == "" {
-				continue
+// tokenizeStatement tokenizes the words of a statement, where statementpos is the position of the statement in the source code
+func (config *TargetConfig) tokenizeStatement(statement string, words []string, offsets []int, statementpos Position) ([]Token, error) {
+	var (
+		tokens []Token
+		t      Token
+		// If we are defining a constant, ease up on tokenizing the rest of the line recursively
+		constexpr = words[0] == "const" // Are we in a constant expression?
+		varexpr   = words[0] == "var"   // Are we in a variable expression?
+		parens    = 0                   // How many parentheses in a constant expression are open?
+	)
+	for i, word := range words {
+		if word == "" {
+			continue
+		}
+		pos := statementpos.at(offsets[i], len(word))
+		// TODO: refactor out code that repeats the same thing
+		if word[0] == '\'' && quoteEnd(word, 0) == len(word)-1 {
+			// A character literal, like 'A' or '\n'
+			value, err := charValue(word)
+			if err != nil {
+				return nil, newCompileError(Token{UNKNOWN, word, pos, ""}, "%s", err)
 			}
-			// TODO: refactor out code that repeats the same thing
-			if instring {
-				collected += word + sep
-			} else if has(registers, word) {
-				t = Token{REGISTER, word, statementnr, "?"}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if has(comparisons, word) {
-				t = Token{COMPARISON, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if has(operators, word) {
-				var tokentype TokenType
-				switch word {
-				case "=":
-					tokentype = ASSIGNMENT
-				case "+=":
-					tokentype = ADDITION
-				case "-=":
-					tokentype = SUBTRACTION
-				case "*=":
-					tokentype = MULTIPLICATION
-				case "/=":
-					tokentype = DIVISION
-				case "&=":
-					tokentype = AND
-				case "|=":
-					tokentype = OR
-				case "^=":
-					tokentype = XOR
-				case "==>":
-					tokentype = OUT
-				case "<==":
-					tokentype = IN
-				case "<<<":
-					tokentype = ROL
-				case ">>>":
-					tokentype = ROR
-				case "<<":
-					tokentype = SHL
-				case ">>":
-					tokentype = SHR
-				case "->":
-					tokentype = ARROW
-				case "<->":
-					tokentype = XCHG
-				default:
-					log.Fatalln("Error: Unhandled operator:", word)
-				}
-				t = Token{tokentype, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if has(keywords, word) {
-				t = Token{KEYWORD, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if has(builtins, word) {
-				t = Token{BUILTIN, word, statementnr, ""}
+			t = Token{VALUE, strconv.Itoa(value), pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if word[0] == '"' || word[0] == '\'' {
+			// A string literal, possibly followed by more values, until the end of the statement
+			rest := statement[offsets[i]:]
+			data, err := dataList(rest)
+			if err != nil {
+				return nil, newCompileError(Token{UNKNOWN, word, pos, ""}, "%s", err)
+			}
+			t = Token{STRING, data, statementpos.at(offsets[i], len(rest)), ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+			break
+		} else if has(registers, word) {
+			t = Token{REGISTER, word, pos, "?"}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if has(comparisons, word) {
+			t = Token{COMPARISON, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if has(logicalOperators, word) {
+			t = Token{LOGICAL, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if has(expressionOperators, word) {
+			t = Token{EXPROP, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if strings.HasPrefix(word, "~") {
+			// Bitwise not in a constant expression, like ~0
+			t = Token{EXPROP, "~", pos.at(0, 1), ""}
+			newtokens, err := config.retokenize(word[1:], " ", pos.at(1, len(word)-1))
+			if err != nil {
+				return nil, err
+			}
+			newtokens = append([]Token{t}, newtokens...)
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if open, inner, close := splitParens(word, parens); (open + close) > 0 {
+			// Parentheses in a constant expression, like "(4" or "1)"
+			for k := 0; k < open; k++ {
+				t = Token{EXPROP, "(", pos.at(k, 1), ""}
 				tokens = append(tokens, t)
-				logtoken(t)
-			} else if has(reserved, word) {
-				if has([]string{"a", "b", "c", "d"}, word) {
-					reg := word
-					switch config.PlatformBits {
-					case 64:
-						reg = "r" + word
-					case 32:
-						reg = "e" + word
-					}
-					reg += "x"
-					t = Token{REGISTER, reg, statementnr, ""}
-				} else {
-					t = Token{RESERVED, word, statementnr, ""}
+				config.logtoken(t)
+			}
+			if inner != "" {
+				newtokens, err := config.retokenize(inner, " ", pos.at(open, len(inner)))
+				if err != nil {
+					return nil, err
 				}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if isValue(word) {
-				t = Token{VALUE, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if word == "_" {
-				t = Token{DISREGARD, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if strings.HasSuffix(word, "++") {
-				firstpart := word[:len(word)-2]
-				newtokens := config.retokenize(firstpart+" += 1", " ")
-				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if strings.HasSuffix(word, "--") {
-				firstpart := word[:len(word)-2]
-				newtokens := config.retokenize(firstpart+" -= 1", " ")
-				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if validName(word) {
-				t = Token{VALIDNAME, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if qualifier(word) {
-				t = Token{QUAL, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if strings.Contains(word, "(") {
-				newtokens := config.retokenize(word, "(")
-				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if strings.Contains(word, ")") {
-				newtokens := config.retokenize(word, ")")
-				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if strings.Contains(word, "[") {
-				newtokens := config.retokenize(word, "[")
-				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if strings.Contains(word, "]") {
-				newtokens := config.retokenize(word, "]")
 				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if (!constexpr && !varexpr) && strings.Contains(word, ",") {
-				newtokens := config.retokenize(word, ",")
-				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if strings.Contains(word, "..") {
-				newtokens := config.retokenize(word, "..")
-				tokens = append(tokens, newtokens...)
-				lognewtokens(newtokens)
-			} else if strings.Contains(word, "\"") {
-				if debug {
-					log.Println("TOKEN", word, "is part of a string")
-					log.Println("ENTERING STRING")
-				}
-				instring = true
-				// TODO: This does not work, see test02.asm and test03.asm
-				if !strings.HasSuffix(word, sep) {
-					if len(collected) == 0 {
-						collected += word + sep
-					} else {
-						collected += word + sep
-					}
-				} else {
-					collected += word + sep + "SEPEND"
-				}
-			} else if strings.Contains("0123456789$", string(word[0])) {
-				// Assume it's a value
-				t = Token{VALUE, word, statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if strings.Contains(word, "+") {
-				// Assume it's an address, like bp+5
-				t = Token{MEMEXP, "[" + word + "]", statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if strings.Contains(word, "-") {
-				// Assume it's an address, like si-0x6
-				t = Token{MEMEXP, "[" + word + "]", statementnr, ""}
-				tokens = append(tokens, t)
-				logtoken(t)
-			} else if strings.HasSuffix(word, ":") {
-				t = Token{ASMLABEL, word, statementnr, ""}
+				config.lognewtokens(newtokens)
+			}
+			for k := 0; k < close; k++ {
+				t = Token{EXPROP, ")", pos.at(open+len(inner)+k, 1), ""}
 				tokens = append(tokens, t)
-				logtoken(t)
-			} else if strings.Count(word, ":") == 1 {
-				regs := strings.Split(word, ":")
-				if has(registers, regs[0]) && has(registers, regs[1]) {
-					// segment:offset
-					t = Token{SEGOFS, "[" + word + "]", statementnr, ""}
-					tokens = append(tokens, t)
-					logtoken(t)
-				} else {
-					log.Fatalln("Unrecognized segment:offset token:", word)
+				config.logtoken(t)
+			}
+			parens += open - close
+		} else if has(operators, word) {
+			var tokentype TokenType
+			switch word {
+			case "=":
+				tokentype = ASSIGNMENT
+			case "+=":
+				tokentype = ADDITION
+			case "-=":
+				tokentype = SUBTRACTION
+			case "*=":
+				tokentype = MULTIPLICATION
+			case "/=", "/u=", "/s=":
+				tokentype = DIVISION
+			case "%=", "%u=", "%s=":
+				tokentype = MODULO
+			case "&=":
+				tokentype = AND
+			case "|=":
+				tokentype = OR
+			case "^=":
+				tokentype = XOR
+			case "==>":
+				tokentype = OUT
+			case "<==":
+				tokentype = IN
+			case "<<<":
+				tokentype = ROL
+			case ">>>":
+				tokentype = ROR
+			case "<<":
+				tokentype = SHL
+			case ">>":
+				tokentype = SHR
+			case ">>s":
+				tokentype = SAR
+			case "->":
+				tokentype = ARROW
+			case "<->":
+				tokentype = XCHG
+			default:
+				return nil, newCompileError(Token{UNKNOWN, word, pos, ""}, "unhandled operator")
+			}
+			t = Token{tokentype, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if has(keywords, word) {
+			t = Token{KEYWORD, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if has(builtins, word) {
+			t = Token{BUILTIN, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if has(reserved, word) {
+			if has([]string{"a", "b", "c", "d"}, word) {
+				reg := word
+				switch config.PlatformBits {
+				case 64:
+					reg = "r" + word
+				case 32:
+					reg = "e" + word
 				}
+				reg += "x"
+				t = Token{REGISTER, reg, pos, ""}
 			} else {
-				log.Println("TOKEN", word, "unknown")
-				log.Fatalln("Error: Unrecognized token:", word)
-				return tokens
+				t = Token{RESERVED, word, pos, ""}
 			}
-		}
-		if instring {
-			if debug {
-				log.Println("EXITING STRING AT END OF STATEMENT")
-				log.Println("STRING:", collected)
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if isValue(word) {
+			if numbits(word) > config.PlatformBits {
+				return nil, newCompileError(Token{VALUE, word, pos, ""}, "value does not fit in %d bits", config.PlatformBits)
+			}
+			t = Token{VALUE, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if word == "_" {
+			t = Token{DISREGARD, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if strings.HasSuffix(word, "++") {
+			firstpart := word[:len(word)-2]
+			newtokens, err := config.retokenize(firstpart, " ", pos.at(0, len(firstpart)))
+			if err != nil {
+				return nil, err
+			}
+			// Replace ++ with += 1
+			ppos := pos.at(len(firstpart), 2)
+			newtokens = append(newtokens, Token{ADDITION, "+=", ppos, ""}, Token{VALUE, "1", ppos, ""})
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if strings.HasSuffix(word, "--") {
+			firstpart := word[:len(word)-2]
+			newtokens, err := config.retokenize(firstpart, " ", pos.at(0, len(firstpart)))
+			if err != nil {
+				return nil, err
 			}
-			t = Token{STRING, stringReplacements(collected), statementnr, ""}
+			// Replace -- with -= 1
+			ppos := pos.at(len(firstpart), 2)
+			newtokens = append(newtokens, Token{SUBTRACTION, "-=", ppos, ""}, Token{VALUE, "1", ppos, ""})
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if strings.HasPrefix(word, "sys.") && validName(word[4:]) {
+			// A named system call, like sys.write(1, msg, len(msg))
+			newtokens, err := config.namedSyscall(word[4:], pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if validName(word) {
+			t = Token{VALIDNAME, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if qualifier(word) {
+			t = Token{QUAL, word, pos, ""}
 			tokens = append(tokens, t)
-			instring = false
-			collected = ""
+			config.logtoken(t)
+		} else if strings.Contains(word, "(") {
+			newtokens, err := config.retokenize(word, "(", pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if strings.Contains(word, ")") {
+			newtokens, err := config.retokenize(word, ")", pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if strings.Contains(word, "[") {
+			newtokens, err := config.retokenize(word, "[", pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if strings.Contains(word, "]") {
+			newtokens, err := config.retokenize(word, "]", pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if (!constexpr && !varexpr) && strings.Contains(word, ",") {
+			newtokens, err := config.retokenize(word, ",", pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if strings.Contains(word, "..") {
+			newtokens, err := config.retokenize(word, "..", pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, newtokens...)
+			config.lognewtokens(newtokens)
+		} else if _, err := parseNumber(word); err == errNumberTooLarge {
+			return nil, newCompileError(Token{VALUE, word, pos, ""}, "value does not fit in 64 bits")
+		} else if strings.Contains("0123456789$", string(word[0])) {
+			// Assume it's a value, like 0ah or $
+			t = Token{VALUE, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if strings.Contains(word, "+") {
+			// Assume it's an address, like bp+5
+			t = Token{MEMEXP, "[" + word + "]", pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if strings.Contains(word, "-") {
+			// Assume it's an address, like si-0x6
+			t = Token{MEMEXP, "[" + word + "]", pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if strings.HasSuffix(word, ":") {
+			t = Token{ASMLABEL, word, pos, ""}
+			tokens = append(tokens, t)
+			config.logtoken(t)
+		} else if strings.Count(word, ":") == 1 {
+			regs := strings.Split(word, ":")
+			if has(registers, regs[0]) && has(registers, regs[1]) {
+				// segment:offset
+				t = Token{SEGOFS, "[" + word + "]", pos, ""}
+				tokens = append(tokens, t)
+				config.logtoken(t)
+			} else {
+				return nil, newCompileError(Token{UNKNOWN, word, pos, ""}, "unrecognized segment:offset token")
+			}
+		} else {
+			return nil, newCompileError(Token{UNKNOWN, word, pos, ""}, "unrecognized token")
 		}
-		t = Token{SEP, ";", statementnr, ""}
-		tokens = append(tokens, t)
-		constexpr = false
-		varexpr = false
 	}
-	return tokens
+	return tokens, nil
 }
 
 // Replace built-in function calls with more basic code
 // Note that only replacements that can be done within one statement will work!
-func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) Statement {
+func (config *TargetConfig) reduce(st Statement, pst *ParseState) (Statement, error) {
 	for i := 0; i < (len(st) - 1); i++ {
-		if (st[i].T == BUILTIN) && (st[i].Value == "len") {
+		if (st[i].T == BUILTIN) && ((st[i].Value == "syscall") || ((st[i].Value == "int") && config.isSyscallInterrupt(st[i+1].Value))) {
+			// Replace the name of a system call, like write in syscall(write, 1, msg, len(msg)), with its number
+			j := i + 1
+			if st[i].Value == "int" {
+				j = i + 2
+			}
+			// Other names are left for the assembler, since they may be defined by asm lines or included files.
+			if (j < len(st)) && ((st[j].T == VALIDNAME) || (st[j].T == KEYWORD) || (st[j].T == BUILTIN)) && (st[j].Value != "_") && !has(pst.definedNames, st[j].Value) {
+				if number, ok := config.syscallNumber(st[j].Value); ok {
+					st[j] = Token{VALUE, number, st[j].Position, ""}
+					config.logf(LogReductions, "Replaced the name of the system call with %v", st[j])
+				}
+			}
+		} else if (st[i].T == BUILTIN) && (st[i].Value == "len") {
 			// The built-in len() function
 
 			var name string
@@ -412,12 +597,12 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 
 				name = st[i+1].Value
 
-				if !has(ps.definedNames, name) {
-					log.Fatalln("Error:", name, "is unfamiliar. Can not find length.")
+				if !has(pst.definedNames, name) {
+					return nil, st.errorf(i+1, "%s is unfamiliar. Can not find length.", name)
 				}
 
 				// TODO: Create a built-in cap() function too
-				//if length, ok := ps.variables[name]; ok {
+				//if length, ok := pst.variables[name]; ok {
 				//	token_type = st[i+1].t
 
 				//	// remove the element at i+1
@@ -433,10 +618,10 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 				st = st[:i+1+copy(st[i+1:], st[i+2:])]
 
 				// replace len(name) with _length_of_name, or [_length_of_name] if it's in .bss
-				if _, ok := ps.variables[name]; ok {
-					st[i] = Token{tokenType, "[_length_of_" + name + "]", st[0].Line, ""}
+				if _, ok := pst.variables[name]; ok {
+					st[i] = Token{tokenType, "[_length_of_" + name + "]", st[i].Position, ""}
 				} else {
-					st[i] = Token{tokenType, "_length_of_" + name, st[0].Line, ""}
+					st[i] = Token{tokenType, "_length_of_" + name, st[i].Position, ""}
 				}
 			} else if st[i+1].T == REGISTER {
 				var length string
@@ -453,14 +638,12 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 				st = st[:i+1+copy(st[i+1:], st[i+2:])]
 
 				// replace len(register) with the appropriate length
-				st[i] = Token{VALUE, length, st[0].Line, ""}
+				st[i] = Token{VALUE, length, st[i].Position, ""}
 			}
 
-			if debug {
-				log.Println("SUCCESSFUL REPLACEMENT WITH", st[i])
-			}
+			config.logf(LogReductions, "Successful replacement with %v", st[i])
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "print") && (st[i+1].T == STRING) {
-			log.Fatalln("Error: print can only print const strings, not immediate strings")
+			return nil, st.errorf(i+1, "print can only print const strings, not immediate strings")
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "print") && ((st[i+1].T == VALIDNAME) || (st[i+1].T == REGISTER)) {
 			// replace print(msg) with
 			// int(0x80, 4, 1, msg, len(msg)) on 32-bit
@@ -477,38 +660,49 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 				tokens   []Token
 				tokenpos int
 				extra    = st[i+1].extra
+				err      error
 			)
+			if config.OS == Windows {
+				// No system calls for printing on Windows, it needs calls to the Windows API
+				return st, nil
+			}
+			write, _ := config.syscallNumber("write")
 			switch config.PlatformBits {
 			case 64:
 				// Special case when printing single bytes, typically from chr(...)
 				if st[i+1].Value == "rsp" {
-					cmd = "syscall(1, 1, " + st[i+1].Value + ", 1)"
+					cmd = "syscall(" + write + ", 1, " + st[i+1].Value + ", 1)"
 				} else {
-					cmd = "syscall(1, 1, " + st[i+1].Value + ", len(" + st[i+1].Value + "))"
+					cmd = "syscall(" + write + ", 1, " + st[i+1].Value + ", len(" + st[i+1].Value + "))"
 				}
-				tokens = config.Tokenize(cmd, " ")
+				tokens, err = config.Tokenize(cmd, " ")
 				// Position of the token that is to be written
 				tokenpos = 3
 			case 32:
 				// Special case when printing single bytes, typically from chr(...)
 				if st[i+1].Value == "esp" {
-					cmd = "int(0x80, 4, 1, " + st[i+1].Value + ", 1)"
+					cmd = "int(0x80, " + write + ", 1, " + st[i+1].Value + ", 1)"
 				} else {
-					cmd = "int(0x80, 4, 1, " + st[i+1].Value + ", len(" + st[i+1].Value + "))"
+					cmd = "int(0x80, " + write + ", 1, " + st[i+1].Value + ", len(" + st[i+1].Value + "))"
 				}
-				tokens = config.Tokenize(cmd, " ")
+				tokens, err = config.Tokenize(cmd, " ")
 				// Position of the token that is to be written
 				tokenpos = 4
 			case 16:
 				// No simple reduction for 16-bit assembly, it needs several lines of assembly code
-				return st
+				return st, nil
+			}
+			if err != nil {
+				return nil, err
 			}
+			// The generated tokens all point to the print call in the source code
+			tokens = setPosition(tokens, st[i].Position)
 
 			tokens[tokenpos].extra = extra
 			// Replace the current statement with the newly generated tokens
 			st = tokens
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "chr") && (st[i+1].T == VALIDNAME) {
-			log.Fatalln("Error: str of a defined name is to be implemented")
+			return nil, st.errorf(i+1, "chr of a defined name is to be implemented")
 		} else if (st[i].T == BUILTIN) && (st[i].Value == "chr") && (st[i+1].T == REGISTER) {
 			register := st[i+1].Value
 
@@ -519,22 +713,37 @@ func (config *TargetConfig) reduce(st Statement, debug bool, ps *ProgramState) S
 				// remove the element at i+1
 				st = st[:i+1+copy(st[i+1:], st[i+2:])]
 				// replace with the register that contains the address of the string
-				st[i] = Token{REGISTER, "rsp", st[0].Line, register} // only a single byte
+				st[i] = Token{REGISTER, "rsp", st[i].Position, register} // only a single byte
 			case 32:
 				// remove the element at i+1
 				st = st[:i+1+copy(st[i+1:], st[i+2:])]
 				// replace with the register that contains the address of the string
-				st[i] = Token{REGISTER, "esp", st[0].Line, register} // only a single byte
+				st[i] = Token{REGISTER, "esp", st[i].Position, register} // only a single byte
 			case 16:
-				log.Fatalln("Error: chr() is not implemented for 16-bit platforms")
+				return nil, st.errorf(i, "chr() is not implemented for 16-bit platforms")
 			}
 		}
 	}
-	return st
+	return st, nil
+}
+
+// TokensToAssembly outputs assembly code given a compilation target config and a slice of tokens.
+//
+// Deprecated: The debug and debug2 flags are ignored. Use CompileTokens, and set
+// TargetConfig.Logger and TargetConfig.LogLevel for logging what is being compiled.
+func (config *TargetConfig) TokensToAssembly(tokens []Token, debug bool, debug2 bool, ps *ProgramState) (string, string, error) {
+	return config.CompileTokens(tokens, ps)
 }
 
-// TokensToAssembly outputs assembly code given a compilation target config and a slice of tokens
-func (config *TargetConfig) TokensToAssembly(tokens []Token, debug bool, debug2 bool, ps *ProgramState) (string, string) {
+// CompileTokens outputs assembly code given a compilation target config and a slice of tokens.
+// Statements that can not be compiled are skipped, so that as many problems as possible can be found.
+// All errors, warnings and notes are collected in the ProgramState, see ProgramState.Diagnostics.
+// If any errors were found, they are returned as Diagnostics.
+// Set TargetConfig.Logger and TargetConfig.LogLevel for logging what is being compiled.
+func (config *TargetConfig) CompileTokens(tokens []Token, ps *ProgramState) (string, string, error) {
+	if err := config.check(); err != nil {
+		return "", "", err
+	}
 	statement := []Token{}
 	asmcode := ""
 	constants := ""
@@ -542,15 +751,19 @@ func (config *TargetConfig) TokensToAssembly(tokens []Token, debug bool, debug2
 	for _, token := range tokens {
 		if token.T == SEP {
 			if len(statement) > 0 {
-				asmline := Statement(statement).String(ps, config)
-				if (statement[0].T == KEYWORD) && (statement[0].Value == "const") {
-					if strings.Contains(asmline, ":") {
-						if debug {
-							log.Printf("CONSTANT: \"%s\"\n", strings.Split(asmline, ":")[0])
-						}
-					} else {
-						log.Fatalln("Error: Unfamiliar constant:", asmline)
-					}
+				isConst := (statement[0].T == KEYWORD) && (statement[0].Value == "const")
+				asmline, err := Statement(statement).String(ps, config)
+				if (err == nil) && isConst && !strings.Contains(asmline, ":") {
+					err = Statement(statement).errorf(0, "unfamiliar constant: %s", asmline)
+				}
+				if err != nil {
+					// Record the error, skip this statement and continue with the next one
+					ps.addError(statement, err)
+					statement = []Token{}
+					continue
+				}
+				if isConst {
+					config.logf(LogCodegen, "CONSTANT: %q", strings.Split(asmline, ":")[0])
 					constants += asmline + "\n"
 				} else if (statement[0].T == KEYWORD) && (statement[0].Value == "var") {
 					// Variables are gathered for the .bss section
@@ -568,7 +781,7 @@ func (config *TargetConfig) TokensToAssembly(tokens []Token, debug bool, debug2
 	if bsscode != "" {
 		asmcode += "\nsection .bss\n" + bsscode
 	}
-	return strings.TrimSpace(constants), asmcode
+	return strings.TrimSpace(constants), asmcode, ps.diagnostics.Err()
 }
 
 // TokenFilter is a function that can check if
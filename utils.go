@@ -1,8 +1,7 @@
 package battlestarlib
 
 import (
-	"math"
-	"strconv"
+	"math/bits"
 )
 
 // Find the position of a string in a list of strings, -1 if not found
@@ -44,13 +43,16 @@ func hasi(il []int, i int) bool {
 	return false
 }
 
-// Given a non-hex number as a string, like "123", return the number of bits of space it takes.
-// For the case of "123" the answer would be 7.
+// Given a number as a string, like "123" or "0x7b", return the number of bits of space it takes.
+// For the case of "123" the answer would be 7. Negative numbers also count the sign bit.
 // Return 0 if it's not a number
 func numbits(number string) int {
-	n, err := strconv.Atoi(number)
+	negative, magnitude, err := parseLiteral(number)
 	if err != nil {
 		return 0
 	}
-	return int(math.Ceil(math.Log2(float64(n))))
+	if negative && magnitude > 0 {
+		return bits.Len64(magnitude-1) + 1
+	}
+	return bits.Len64(magnitude)
 }
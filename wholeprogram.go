@@ -1,15 +1,12 @@
 package battlestarlib
 
 import (
-	"log"
 	"strings"
 )
 
-// ExtractInlineC retrieves the C code between:
-//   inline_c...end
-// or
-//   void...}
-func ExtractInlineC(code string, debug bool) string {
+// ExtractInlineC retrieves the C code between "inline_c" and "end", or between "void" and "}".
+// The blocks that are found are logged at the LogTokens level.
+func (config *TargetConfig) ExtractInlineC(code string) string {
 	var (
 		clines       string
 		inBlockType1 bool
@@ -23,21 +20,21 @@ func ExtractInlineC(code string, debug bool) string {
 		}
 		//log.Println("firstword: "+ firstword)
 		if !inBlockType2 && !inBlockType1 && (firstword == "inline_c") {
-			log.Println("found", firstword, "starting inline_c block")
+			config.logf(LogTokens, "Found %s, starting inline_c block", firstword)
 			inBlockType1 = true
 			// Don't include "inline_c" in the inline C code
 			continue
 		} else if !inBlockType1 && !inBlockType2 && (firstword == "void") {
-			log.Println("found", firstword, "starting inBlockType2 block")
+			config.logf(LogTokens, "Found %s, starting void block", firstword)
 			inBlockType2 = true
 			// Include "void" in the inline C code
 		} else if !inBlockType2 && inBlockType1 && (firstword == "end") {
-			log.Println("found", firstword, "ending inline_c block")
+			config.logf(LogTokens, "Found %s, ending inline_c block", firstword)
 			inBlockType1 = false
 			// Don't include "end" in the inline C code
 			continue
 		} else if !inBlockType1 && inBlockType2 && (firstword == "}") {
-			log.Println("found", firstword, "ending inBlockType2 block")
+			config.logf(LogTokens, "Found %s, ending void block", firstword)
 			inBlockType2 = false
 			// Include "}" in the inline C code
 		}
@@ -97,33 +94,65 @@ func (config *TargetConfig) AddExternMainIfMissing(btsCode string) string {
 
 // AddStartingPointIfMissing will check if the resulting code contains a starting point or not,
 // and add one if it is missing.
-func (config *TargetConfig) AddStartingPointIfMissing(asmcode string, ps *ProgramState) string {
+func (config *TargetConfig) AddStartingPointIfMissing(asmcode string, ps *ProgramState) (string, error) {
+	if err := config.check(); err != nil {
+		return "", err
+	}
+	asmcode, err := config.addStartingPoint(asmcode, ps)
+	if err != nil {
+		return "", err
+	}
+	if (config.OS == Windows) && !strings.Contains(asmcode, "section .text") {
+		// The code is placed in the .text section of the PE/COFF object file
+		asmcode = "section .text\n" + asmcode
+	}
+	if config.ripRelative() && !strings.Contains(asmcode, "default rel") {
+		// Let memory references like [msg] be relative to the instruction pointer
+		asmcode = "default rel\n" + asmcode
+	}
+	if (config.OS == OpenBSD) && !config.bootable(ps) && !strings.Contains(asmcode, ".note.openbsd.ident") {
+		asmcode += openBSDNote
+	}
+	if (config.OS == FreeBSD) && !config.bootable(ps) && !strings.Contains(asmcode, ".note.tag") {
+		asmcode += freeBSDNote
+	}
+	return asmcode, nil
+}
+
+// addStartingPoint adds a starting point for the linker, if it is missing
+func (config *TargetConfig) addStartingPoint(asmcode string, ps *ProgramState) (string, error) {
 	if strings.Contains(asmcode, "extern "+config.LinkerStartFunction) {
-		log.Println("External starting point for linker, not adding one.")
-		return asmcode
+		config.logf(LogCodegen, "External starting point for linker, not adding one.")
+		return asmcode, nil
 	}
 	if !strings.Contains(asmcode, config.LinkerStartFunction) {
-		log.Printf("No %s has been defined, creating one\n", config.LinkerStartFunction)
+		config.logf(LogCodegen, "No %s has been defined, creating one", config.LinkerStartFunction)
 		var addstring string
 		if config.PlatformBits != 16 {
 			addstring += "global " + config.LinkerStartFunction + "\t\t\t; make label available to the linker\n"
 		}
 		addstring += config.LinkerStartFunction + ":\t\t\t\t; starting point of the program\n"
+		if config.ripRelative() {
+			addstring += "\tand rsp, -16\t\t\t; align the stack to 16 bytes, as required on macOS\n"
+		}
 		if strings.Contains(asmcode, "extern main") {
 			//log.Println("External main function, adding starting point that calls it.")
-			linenr := uint(strings.Count(asmcode+addstring, "\n") + 5)
-			// TODO: Check that this is the correct linenr
-			exitStatement := Statement{Token{BUILTIN, "exit", linenr, ""}}
-			return asmcode + "\n" + addstring + "\n\tcall main\t\t; call the external main function\n\n" + exitStatement.String(ps, config)
+			// This exit statement is not in the source code, so it has no position
+			exitStatement := Statement{Token{BUILTIN, "exit", Position{}, ""}}
+			exitcode, err := exitStatement.String(ps, config)
+			if err != nil {
+				return "", err
+			}
+			return asmcode + "\n" + addstring + "\n\tcall main\t\t; call the external main function\n\n" + exitcode, nil
 		} else if strings.Contains(asmcode, "\nmain:") {
 			//log.Println("...but main has been defined, using that as starting point.")
 			// Add "_start:"/"start" right after "main:"
-			return strings.Replace(asmcode, "\nmain:", "\n"+addstring+"main:", 1)
+			return strings.Replace(asmcode, "\nmain:", "\n"+addstring+"main:", 1), nil
 		}
-		return addstring + "\n" + asmcode
+		return addstring + "\n" + asmcode, nil
 
 	}
-	return asmcode
+	return asmcode, nil
 }
 
 // AddExitTokenIfMissing will check if the code has an exit or ret and
@@ -162,12 +191,14 @@ func (config *TargetConfig) AddExitTokenIfMissing(tokens []Token) []Token {
 	newtokens := make([]Token, len(tokens)+2)
 	copy(newtokens, tokens)
 
-	// TODO: Check that the line nr is correct
-	retToken := Token{BUILTIN, "exit", newtokens[len(newtokens)-1].Line, ""}
+	// The added tokens point to the end of the last token in the source code
+	lastpos := tokens[len(tokens)-1].Position
+	lastpos = lastpos.at(lastpos.Length, 0)
+
+	retToken := Token{BUILTIN, "exit", lastpos, ""}
 	newtokens[len(tokens)] = retToken
 
-	// TODO: Check that the line nr is correct
-	sepToken := Token{SEP, ";", newtokens[len(newtokens)-1].Line, ""}
+	sepToken := Token{SEP, ";", lastpos, ""}
 	newtokens[len(tokens)+1] = sepToken
 
 	return newtokens
@@ -0,0 +1,97 @@
+package battlestarlib
+
+import (
+	"strconv"
+)
+
+// windowsFunction returns the name of a function in the Windows API, given the number of bytes its arguments take on the stack.
+// Win32 functions use the stdcall calling convention, and the names are decorated, like "_ExitProcess@4".
+func (config *TargetConfig) windowsFunction(name string, argBytes int) string {
+	if config.PlatformBits == 32 {
+		return "_" + name + "@" + strconv.Itoa(argBytes)
+	}
+	return name
+}
+
+// importFunction declares a function from the Windows API as external, the first time it is used
+func (config *TargetConfig) importFunction(name string, ps *ProgramState) string {
+	if has(ps.imports, name) {
+		return ""
+	}
+	ps.imports = append(ps.imports, name)
+	return "extern " + name + "\t\t\t; from the Windows API\n"
+}
+
+// windowsExit exits the program by calling ExitProcess from the Windows API
+func (config *TargetConfig) windowsExit(exitCode string, ps *ProgramState) string {
+	exitProcess := config.windowsFunction("ExitProcess", 4)
+	asmcode := config.importFunction(exitProcess, ps)
+	if config.PlatformBits == 32 {
+		if has(registers, exitCode) {
+			asmcode += "\tpush " + exitCode + "\t\t\t\t; exit code " + exitCode + "\n"
+		} else {
+			asmcode += "\tpush DWORD " + exitCode + "\t\t\t; exit code " + exitCode + "\n"
+		}
+		return asmcode + "\tcall " + exitProcess + "\t\t; exit program\n"
+	}
+	if exitCode == "0" {
+		asmcode += "\txor ecx, ecx"
+	} else {
+		asmcode += "\tmov rcx, " + exitCode
+	}
+	asmcode += "\t\t\t; exit code " + exitCode + "\n"
+	asmcode += "\tand rsp, -16\t\t\t; align the stack to 16 bytes\n"
+	asmcode += "\tsub rsp, " + strconv.Itoa(microsoftX64.shadowSpace) + "\t\t\t; shadow space for the called function\n"
+	return asmcode + "\tcall " + exitProcess + "\t\t; exit program\n"
+}
+
+// windowsPrint writes a string to standard output, by calling GetStdHandle and WriteFile from the Windows API
+func (config *TargetConfig) windowsPrint(name string, ps *ProgramState) string {
+	getStdHandle := config.windowsFunction("GetStdHandle", 4)
+	writeFile := config.windowsFunction("WriteFile", 20)
+	asmcode := config.importFunction(getStdHandle, ps) + config.importFunction(writeFile, ps)
+	asmcode += "\t; --- output string of given length ---\n"
+	_, isVariable := ps.variables[name]
+	if config.PlatformBits == 32 {
+		// The Windows API functions remove their own arguments from the stack
+		asmcode += "\tpush DWORD 0\t\t\t; room for the number of bytes written\n"
+		asmcode += "\tpush DWORD -11\t\t\t; STD_OUTPUT_HANDLE\n"
+		asmcode += "\tcall " + getStdHandle + "\n"
+		asmcode += "\tmov ecx, esp\t\t\t; where the number of bytes written is stored\n"
+		if isVariable {
+			// A variable in .bss
+			asmcode += "\tmovzx edx, WORD [_length_of_" + name + "]\n"
+		} else {
+			asmcode += "\tmov edx, _length_of_" + name + "\n"
+		}
+		asmcode += "\tpush DWORD 0\t\t\t; no overlapped structure\n"
+		asmcode += "\tpush ecx\n"
+		asmcode += "\tpush edx\t\t\t; the length of the string\n"
+		asmcode += "\tpush " + name + "\t\t\t; the string\n"
+		asmcode += "\tpush eax\t\t\t; the handle for standard output\n"
+		asmcode += "\tcall " + writeFile + "\n"
+		asmcode += "\tadd esp, 4\t\t\t; remove the number of bytes written\n\n"
+		return asmcode
+	}
+	// Store the stack pointer twice, so that it can be restored no matter how much the alignment moves it
+	asmcode += "\tpush rsp\t\t\t; save the stack pointer\n"
+	asmcode += "\tpush QWORD [rsp]\n"
+	asmcode += "\tand rsp, -16\t\t\t; align the stack to 16 bytes\n"
+	asmcode += "\tsub rsp, 48\t\t\t; shadow space, the fifth argument and the number of bytes written\n"
+	asmcode += "\tmov rcx, -11\t\t\t; STD_OUTPUT_HANDLE\n"
+	asmcode += "\tcall " + getStdHandle + "\n"
+	asmcode += "\tmov rcx, rax\t\t\t; the handle for standard output\n"
+	asmcode += "\tmov rdx, " + name + "\t\t\t; the string\n"
+	if isVariable {
+		// A variable in .bss
+		asmcode += "\tmov r8d, [_length_of_" + name + "]\t; the length of the string\n"
+	} else {
+		asmcode += "\tmov r8, _length_of_" + name + "\t\t; the length of the string\n"
+	}
+	asmcode += "\tlea r9, [rsp+40]\t\t; where the number of bytes written is stored\n"
+	asmcode += "\tmov QWORD [rsp+32], 0\t\t; no overlapped structure\n"
+	asmcode += "\tcall " + writeFile + "\n"
+	asmcode += "\tadd rsp, 48\n"
+	asmcode += "\tmov rsp, [rsp+8]\t\t; restore the stack pointer\n\n"
+	return asmcode
+}